@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// loadBaseline reads the JSON summary of an earlier run from filename, as
+// written by --format json. The file may also be a history file, in which
+// case the last run in it is the baseline.
+func loadBaseline(filename string) (jsonSummary, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return jsonSummary{}, err
+	}
+	defer f.Close()
+
+	var baseline jsonSummary
+	found := false
+	dec := json.NewDecoder(f)
+	for {
+		var js jsonSummary
+		err := dec.Decode(&js)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return jsonSummary{}, fmt.Errorf("invalid baseline %s: %w", filename, err)
+		}
+		baseline, found = js, true
+	}
+	if !found {
+		return jsonSummary{}, fmt.Errorf("invalid baseline %s: no summary", filename)
+	}
+	return baseline, nil
+}
+
+// baselineChange is the change of a metric of the summary from the baseline.
+type baselineChange struct {
+	name              string
+	baseline, current string
+	// percent is the change from the baseline as a percentage of it, and
+	// valid is false if the baseline is zero so there is no percentage.
+	percent float64
+	valid   bool
+	// regressed is true if the change is worse than the threshold.
+	regressed bool
+}
+
+// compareBaseline returns the changes of the mean, median, p95 and
+// throughput of current from baseline. A duration that increased, or a
+// throughput that decreased, by more than threshold percent is a regression.
+// Durations are formatted by f.
+func compareBaseline(baseline, current jsonSummary, threshold float64, f durationFormat) []baselineChange {
+	durations := []struct {
+		name              string
+		baseline, current int64
+	}{
+		{"mean", baseline.Mean, current.Mean},
+		{"median", baseline.Median, current.Median},
+		{"p95", baseline.P95, current.P95},
+	}
+	var changes []baselineChange
+	for _, d := range durations {
+		c := baselineChange{
+			name:     d.name,
+			baseline: f.format(time.Duration(d.baseline)),
+			current:  f.format(time.Duration(d.current)),
+		}
+		if d.baseline != 0 {
+			c.percent, c.valid = 100*float64(d.current-d.baseline)/float64(d.baseline), true
+			c.regressed = c.percent > threshold
+		}
+		changes = append(changes, c)
+	}
+
+	c := baselineChange{
+		name:     "throughput",
+		baseline: fmt.Sprintf("%.1f queries/s", baseline.Throughput),
+		current:  fmt.Sprintf("%.1f queries/s", current.Throughput),
+	}
+	if baseline.Throughput != 0 {
+		c.percent, c.valid = 100*(current.Throughput-baseline.Throughput)/baseline.Throughput, true
+		c.regressed = -c.percent > threshold
+	}
+	return append(changes, c)
+}
+
+// printBaselineChanges writes changes to w, highlighting regressions with
+// the colours of p. It returns an error if any change is a regression.
+func printBaselineChanges(w io.Writer, changes []baselineChange, p palette) error {
+	fmt.Fprintln(w, "Change from baseline:")
+	regressed := false
+	for _, c := range changes {
+		line := fmt.Sprintf("  %s: %s -> %s", c.name, c.baseline, c.current)
+		if c.valid {
+			line += fmt.Sprintf(" (%+.1f%%)", c.percent)
+		}
+		if c.regressed {
+			regressed = true
+			line = p.red(line + " regression")
+		}
+		fmt.Fprintln(w, line)
+	}
+	if regressed {
+		return errors.New("performance regressed from the baseline")
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBaseline(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "baseline.json")
+	require.NoError(t, ioutil.WriteFile(filename, []byte(`{"count":2,"mean_ns":1500000,"throughput_qps":100}`+"\n"), 0o600))
+	baseline, err := loadBaseline(filename)
+	require.NoError(t, err)
+	require.Equal(t, int64(1500000), baseline.Mean)
+	require.Equal(t, 100.0, baseline.Throughput)
+
+	// The last run of a history file is the baseline.
+	history := filepath.Join(dir, "history.jsonl")
+	summary := querySummary{count: 1, mean: time.Millisecond}
+	require.NoError(t, appendHistory(history, "", time.Now(), summary))
+	summary.mean = 2 * time.Millisecond
+	require.NoError(t, appendHistory(history, "", time.Now(), summary))
+	baseline, err = loadBaseline(history)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*time.Millisecond), baseline.Mean)
+
+	empty := filepath.Join(dir, "empty.json")
+	require.NoError(t, ioutil.WriteFile(empty, nil, 0o600))
+	_, err = loadBaseline(empty)
+	require.Error(t, err)
+}
+
+func TestCompareBaseline(t *testing.T) {
+	baseline := jsonSummary{Mean: int64(10 * time.Millisecond), Median: int64(8 * time.Millisecond), Throughput: 100}
+	current := jsonSummary{Mean: int64(11 * time.Millisecond), Median: int64(6 * time.Millisecond), P95: int64(time.Millisecond), Throughput: 85}
+	changes := compareBaseline(baseline, current, 10, durationFormat{})
+
+	var buf bytes.Buffer
+	err := printBaselineChanges(&buf, changes, palette{})
+	require.EqualError(t, err, "performance regressed from the baseline")
+	require.Equal(t, "Change from baseline:\n"+
+		"  mean: 10ms -> 11ms (+10.0%)\n"+
+		"  median: 8ms -> 6ms (-25.0%)\n"+
+		"  p95: 0s -> 1ms\n"+
+		"  throughput: 100.0 queries/s -> 85.0 queries/s (-15.0%) regression\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, printBaselineChanges(&buf, compareBaseline(baseline, current, 20, durationFormat{}), palette{}))
+
+	changes = compareBaseline(baseline, current, 10, newDurationFormat("ms", 1))
+	require.Equal(t, "10.0ms", changes[0].baseline)
+	require.Equal(t, "11.0ms", changes[0].current)
+}
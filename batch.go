@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batcher is implemented by a queryExecutor that can execute several queries
+// in a single statement, and so a single round-trip to the database.
+type batcher interface {
+	// executeBatch executes the queries of batch, returning a result for
+	// each in the same order.
+	executeBatch(ctx context.Context, batch []query) ([]queryResult, error)
+}
+
+// queryer executes SQL that is not prepared. It is implemented by *sql.DB
+// and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// batch returns the SQL and arguments executing the statement of b for each
+// of the queries of batch in a single statement. The statement for each
+// query is a subquery whose rows are prefixed with the index of the query in
+// batch, and the subqueries are combined with UNION ALL. The parameters of
+// each subquery are renumbered to follow those of the previous one.
+func (b benchmarkSQL) batch(batch []query) (string, []interface{}) {
+	parts := make([]string, len(batch))
+	args := make([]interface{}, 0, len(batch)*len(b.params))
+	for i, q := range batch {
+		offset := len(args)
+		text := sqlParamRE.ReplaceAllStringFunc(b.text, func(p string) string {
+			n, _ := strconv.Atoi(p[1:])
+			return "$" + strconv.Itoa(n+offset)
+		})
+		parts[i] = fmt.Sprintf("SELECT %d AS batch_index, b.* FROM (%s) AS b", i, text)
+		args = append(args, b.args(q)...)
+	}
+	return strings.Join(parts, " UNION ALL "), args
+}
+
+// executeBatch executes the queries of batch in a single statement. See
+// benchmarkSQL.batch. The durations of the statement are divided equally
+// between the results of the queries.
+func (e *stmtExecutor) executeBatch(ctx context.Context, batch []query) ([]queryResult, error) {
+	text, args := e.bsql.batch(batch)
+	qStart := time.Now()
+
+	rows, err := e.db.QueryContext(ctx, text, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	executeDuration := time.Since(qStart)
+
+	results := make([]queryResult, len(batch))
+	for i, q := range batch {
+		results[i] = queryResult{query: q, noData: true}
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var firstRow time.Duration
+	for rows.Next() {
+		if firstRow == 0 {
+			firstRow = time.Since(qStart)
+		}
+		var i int
+		var minCPU, maxCPU, value sql.NullFloat64
+		dest := []interface{}{&i}
+		switch {
+		case e.bsql.minMax:
+			dest = append(dest, &minCPU, &maxCPU)
+		case e.bsql.aggregate:
+			dest = append(dest, &value)
+		case e.bsql.buckets:
+			dest = append(dest, new(interface{}), &minCPU, &maxCPU)
+		default:
+			for range columns[1:] {
+				dest = append(dest, new(sql.RawBytes))
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(results) {
+			return nil, fmt.Errorf("invalid batch index in result: %d", i)
+		}
+		qr := &results[i]
+		switch {
+		case e.bsql.minMax:
+			// The aggregates are NULL if there is no data in the window.
+			qr.minCPU, qr.maxCPU = minCPU.Float64, maxCPU.Float64
+			qr.noData = !minCPU.Valid
+			qr.hasCPU = minCPU.Valid
+		case e.bsql.buckets:
+			qr.buckets++
+			if minCPU.Valid {
+				if qr.noData || minCPU.Float64 < qr.minCPU {
+					qr.minCPU = minCPU.Float64
+				}
+				if qr.noData || maxCPU.Float64 > qr.maxCPU {
+					qr.maxCPU = maxCPU.Float64
+				}
+				qr.noData = false
+				qr.hasCPU = true
+			}
+		case e.bsql.aggregate:
+			qr.noData = e.bsql.noData(value)
+		default:
+			qr.noData = false
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	queryDuration := time.Since(qStart)
+	n := time.Duration(len(batch))
+	for i := range results {
+		results[i].executeDuration = executeDuration / n
+		results[i].firstRowDuration = firstRow / n
+		results[i].queryDuration = queryDuration / n
+	}
+	return results, nil
+}
+
+// batchWorker is a worker that executes the queries on the input channel in
+// batches of up to config.BatchSize adjacent queries for the same hostname,
+// sending the result of each query on the output channel. A query for
+// another hostname ends the batch and starts the next one.
+//
+// If config.QueryTimeout is set, it applies to each batch, and all the
+// queries of a batch that times out are sent as timedOut results. If
+// config.ContinueOnError is set, all the queries of a batch that fails are
+// sent as results with its error.
+func batchWorker(ctx context.Context, config *CLI, id int, exec batcher, input <-chan query, output chan<- queryResult, blocked *backpressure) error {
+	var q query
+	pending := false
+	for {
+		if !pending && !recvQuery(ctx, &q, input) {
+			return nil
+		}
+		batch := []query{q}
+		pending = false
+		for len(batch) < config.BatchSize && recvQuery(ctx, &q, input) {
+			if q.hostname != batch[0].hostname {
+				pending = true
+				break
+			}
+			batch = append(batch, q)
+		}
+
+		results, err := executeBatchWithTimeout(ctx, config.QueryTimeout, exec, batch)
+		if err != nil && config.ContinueOnError && ctx.Err() == nil {
+			results = make([]queryResult, len(batch))
+			for i, q := range batch {
+				results[i] = queryResult{query: q, err: err}
+			}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, qr := range results {
+			qr.worker = id
+			if config.verbose != nil {
+				logResult(config.verbose, qr)
+			}
+			ok, d := sendQueryResultTimed(ctx, qr, output)
+			blocked.add(d)
+			if !ok {
+				return nil
+			}
+		}
+	}
+}
+
+// executeBatchWithTimeout executes batch with exec, abandoning it if it takes
+// longer than timeout. The queries of an abandoned batch are returned as
+// timedOut results. A zero timeout means the batch is never abandoned.
+func executeBatchWithTimeout(ctx context.Context, timeout time.Duration, exec batcher, batch []query) ([]queryResult, error) {
+	if timeout == 0 {
+		return exec.executeBatch(ctx, batch)
+	}
+	qctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results, err := exec.executeBatch(qctx, batch)
+	if err != nil && qctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		results = make([]queryResult, len(batch))
+		for i, q := range batch {
+			results[i] = queryResult{query: q, timedOut: true}
+		}
+		return results, nil
+	}
+	return results, err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBatcher is a queryExecutor and batcher that records the batches it
+// executes, each taking duration, and fails the batches with fail.
+type fakeBatcher struct {
+	fakeExecutor
+	fail error
+
+	mu      sync.Mutex
+	batches [][]query
+}
+
+func (e *fakeBatcher) executeBatch(ctx context.Context, batch []query) ([]queryResult, error) {
+	e.mu.Lock()
+	e.batches = append(e.batches, batch)
+	e.mu.Unlock()
+	if e.fail != nil {
+		return nil, e.fail
+	}
+	results := make([]queryResult, len(batch))
+	for i, q := range batch {
+		results[i] = queryResult{query: q, queryDuration: e.duration / time.Duration(len(batch))}
+	}
+	return results, nil
+}
+
+func TestBenchmarkSQLBatch(t *testing.T) {
+	bsql, err := querySQL(&CLI{})
+	require.NoError(t, err)
+	text, args := bsql.batch([]query{good1Query, good2Query})
+	require.Equal(t, "SELECT 0 AS batch_index, b.* FROM (SELECT min(usage), max(usage) FROM cpu_usage WHERE host = $1 AND ts >= $2 AND ts <= $3) AS b"+
+		" UNION ALL SELECT 1 AS batch_index, b.* FROM (SELECT min(usage), max(usage) FROM cpu_usage WHERE host = $4 AND ts >= $5 AND ts <= $6) AS b", text)
+	require.Equal(t, []interface{}{good1Query.hostname, good1Query.start, good1Query.end, good2Query.hostname, good2Query.start, good2Query.end}, args)
+}
+
+func TestBatchWorker(t *testing.T) {
+	q1 := query{hostname: good1Query.hostname, start: good1Query.start, end: good1Query.end}
+	q2 := query{hostname: good1Query.hostname, start: good2Query.start, end: good2Query.end}
+	q3 := query{hostname: good1Query.hostname, start: good2Query.end, end: good2Query.end}
+	exec := &fakeBatcher{fakeExecutor: fakeExecutor{duration: 4 * time.Millisecond}}
+	config := &CLI{Workers: 1, BatchSize: 2}
+
+	results, err := execute(config, exec, q1, q2, good2Query, q3)
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+	// A batch ends when it is full or at a query for another hostname.
+	require.Equal(t, [][]query{{q1, q2}, {good2Query}, {q3}}, exec.batches)
+	require.Empty(t, exec.executed)
+	require.Equal(t, 2*time.Millisecond, results[1].queryDuration)
+
+	exec = &fakeBatcher{fail: errors.New("batch failed")}
+	_, err = execute(config, exec, q1, q2)
+	require.EqualError(t, err, "batch failed")
+
+	config.ContinueOnError = true
+	results, err = execute(config, exec, q1, q2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	require.EqualError(t, results[0].err, "batch failed")
+	require.EqualError(t, results[1].err, "batch failed")
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// bucketInterval returns the SQL interval of a time bucket of width d, in
+// whole seconds if it is a number of seconds, otherwise in microseconds.
+func bucketInterval(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("'%d seconds'::interval", d/time.Second)
+	}
+	return fmt.Sprintf("'%d microseconds'::interval", d/time.Microsecond)
+}
+
+// readBuckets reads all of rows, the result of a bucket query, into qr. Each
+// row is a time bucket with the minimum and maximum CPU usage in it. qr gets
+// the number of buckets, the time from start until the first row was
+// available, and the minimum and maximum CPU usage over all the buckets.
+// It has no data if there are no buckets.
+func readBuckets(rows resultRows, start time.Time, qr *queryResult) error {
+	qr.noData = true
+	for rows.Next() {
+		var bucket interface{}
+		var minCPU, maxCPU sql.NullFloat64
+		if err := rows.Scan(&bucket, &minCPU, &maxCPU); err != nil {
+			return err
+		}
+		if qr.buckets == 0 {
+			qr.firstRowDuration = time.Since(start)
+		}
+		qr.buckets++
+		if !minCPU.Valid {
+			continue
+		}
+		if qr.noData || minCPU.Float64 < qr.minCPU {
+			qr.minCPU = minCPU.Float64
+		}
+		if qr.noData || maxCPU.Float64 > qr.maxCPU {
+			qr.maxCPU = maxCPU.Float64
+		}
+		qr.noData = false
+		qr.hasCPU = true
+	}
+	return rows.Err()
+}
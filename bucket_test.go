@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// bucketRows is a resultRows of the rows of a bucket query, one for each of
+// the minimum and maximum pairs of buckets. A nil pair is a bucket with no
+// data.
+type bucketRows struct {
+	buckets [][]float64
+}
+
+func (r *bucketRows) Next() bool {
+	return len(r.buckets) > 0
+}
+
+func (r *bucketRows) Scan(dest ...interface{}) error {
+	b := r.buckets[0]
+	r.buckets = r.buckets[1:]
+	*dest[0].(*interface{}) = time.Time{}
+	if b != nil {
+		*dest[1].(*sql.NullFloat64) = sql.NullFloat64{Float64: b[0], Valid: true}
+		*dest[2].(*sql.NullFloat64) = sql.NullFloat64{Float64: b[1], Valid: true}
+	}
+	return nil
+}
+
+func (r *bucketRows) Err() error { return nil }
+
+func TestReadBuckets(t *testing.T) {
+	var qr queryResult
+	rows := &bucketRows{buckets: [][]float64{{5, 10}, nil, {2, 8}, {4, 12}}}
+	require.NoError(t, readBuckets(rows, time.Now(), &qr))
+	require.Equal(t, 4, qr.buckets)
+	require.Equal(t, 2.0, qr.minCPU)
+	require.Equal(t, 12.0, qr.maxCPU)
+	require.False(t, qr.noData)
+	require.True(t, qr.hasCPU)
+
+	qr = queryResult{}
+	require.NoError(t, readBuckets(&bucketRows{}, time.Now(), &qr))
+	require.Equal(t, 0, qr.buckets)
+	require.True(t, qr.noData)
+}
+
+func TestQuerySQLBucket(t *testing.T) {
+	config := &CLI{Query: "bucket"}
+	bsql, err := querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT time_bucket('60 seconds'::interval, ts) AS bucket, min(usage), max(usage) FROM cpu_usage WHERE host = $1 AND ts >= $2 AND ts <= $3 GROUP BY 1 ORDER BY 1", bsql.text)
+	require.True(t, bsql.buckets)
+
+	config.Bucket = 90 * time.Second
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.Contains(t, bsql.text, "time_bucket('90 seconds'::interval, ts)")
+
+	require.Equal(t, "'1500 microseconds'::interval", bucketInterval(1500*time.Microsecond))
+}
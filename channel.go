@@ -1,6 +1,9 @@
 package main
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // sendQuery sends the query q on the output channel and returns true if it was
 // able to send it before the context is cancelled, otherwise false is returned.
@@ -33,6 +36,20 @@ func sendQueryResult(ctx context.Context, qr queryResult, output chan<- queryRes
 	}
 }
 
+// sendQueryResultTimed is sendQueryResult, additionally returning how long
+// it blocked waiting for the receiver. The time is zero if the receiver was
+// ready.
+func sendQueryResultTimed(ctx context.Context, qr queryResult, output chan<- queryResult) (bool, time.Duration) {
+	select {
+	case output <- qr:
+		return true, 0
+	default:
+	}
+	start := time.Now()
+	ok := sendQueryResult(ctx, qr, output)
+	return ok, time.Since(start)
+}
+
 func recvQueryResult(ctx context.Context, qr *queryResult, input <-chan queryResult) (ok bool) {
 	select {
 	case <-ctx.Done():
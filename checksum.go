@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+)
+
+// resultChecksum computes a checksum of the data returned by the queries: the
+// hostname and minimum and maximum CPU usage of each result. The results are
+// sorted before hashing so the checksum does not depend on the order the
+// queries completed in, only on the data. Every result is kept in memory
+// until the checksum is computed.
+type resultChecksum struct {
+	rows []string
+}
+
+// observe adds the data of qr to the checksum.
+func (c *resultChecksum) observe(qr queryResult) {
+	row := qr.query.hostname + "\x00"
+	if qr.noData {
+		row += "-\x00-"
+	} else {
+		row += strconv.FormatFloat(qr.minCPU, 'g', -1, 64) + "\x00" + strconv.FormatFloat(qr.maxCPU, 'g', -1, 64)
+	}
+	c.rows = append(c.rows, row)
+}
+
+// sum returns the checksum of the results observed as a hex SHA-256 hash.
+func (c *resultChecksum) sum() string {
+	sort.Strings(c.rows)
+	h := sha256.New()
+	for _, row := range c.rows {
+		h.Write([]byte(row))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
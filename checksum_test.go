@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummariseResultsChecksum(t *testing.T) {
+	results := []queryResult{
+		{query: good1Query, minCPU: 1.5, maxCPU: 90},
+		{query: good2Query, minCPU: 2, maxCPU: 80},
+		{query: good1Query, noData: true},
+	}
+	summary, err := summariseWith(&CLI{Checksum: true}, results...)
+	require.NoError(t, err)
+	require.Len(t, summary.checksum, 64)
+
+	// The order of the results does not matter, but their data does.
+	reordered, err := summariseWith(&CLI{Checksum: true}, results[2], results[0], results[1])
+	require.NoError(t, err)
+	require.Equal(t, summary.checksum, reordered.checksum)
+	results[1].maxCPU = 81
+	changed, err := summariseWith(&CLI{Checksum: true}, results...)
+	require.NoError(t, err)
+	require.NotEqual(t, summary.checksum, changed.checksum)
+
+	summary, err = summariseWith(&CLI{}, results...)
+	require.NoError(t, err)
+	require.Empty(t, summary.checksum)
+}
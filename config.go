@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// redacted replaces secrets in the configuration dump.
+const redacted = "REDACTED"
+
+// configDump returns the effective configuration of config keyed by flag
+// name, for recording alongside the results of a run. The password,
+// including any in the database URL or connection parameters, is redacted.
+func configDump(config *CLI) map[string]interface{} {
+	dump := map[string]interface{}{}
+	v := reflect.ValueOf(config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		name := field.Tag.Get("name")
+		if name == "" {
+			name = kebabCase(field.Name)
+		}
+
+		switch value := v.Field(i).Interface().(type) {
+		case *os.File:
+			if value != nil {
+				dump[name] = value.Name()
+			}
+		case []*os.File:
+			if len(value) > 0 {
+				names := make([]string, 0, len(value))
+				for _, f := range value {
+					names = append(names, f.Name())
+				}
+				dump[name] = names
+			}
+		case time.Duration:
+			dump[name] = value.String()
+		default:
+			dump[name] = value
+		}
+	}
+
+	if config.Password != "" {
+		dump["password"] = redacted
+	}
+	if config.DBUrl != "" {
+		dump["db-url"] = redactURL(config.DBUrl)
+	}
+	if _, ok := config.DBParams["password"]; ok {
+		params := map[string]string{}
+		for k, v := range config.DBParams {
+			params[k] = v
+		}
+		params["password"] = redacted
+		dump["db-param"] = params
+	}
+	return dump
+}
+
+// dsnPasswordRE matches the password of a keyword/value connection string,
+// such as host=db password='a secret', capturing everything before the
+// value.
+var dsnPasswordRE = regexp.MustCompile(`(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactURL returns rawURL, a database URL or keyword/value connection
+// string, with any password redacted. A URL's password may be in its user
+// info or its password parameter. If a URL cannot be parsed, it is redacted
+// entirely as it may contain a password.
+func redactURL(rawURL string) string {
+	if !isDatabaseURL(rawURL) {
+		return dsnPasswordRE.ReplaceAllString(rawURL, "${1}"+redacted)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return redacted
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if params := u.Query(); params.Get("password") != "" {
+		params.Set("password", redacted)
+		u.RawQuery = params.Encode()
+	}
+	return u.String()
+}
+
+// isDatabaseURL returns true if dsn is a postgres:// or postgresql:// URL,
+// rather than a keyword/value connection string.
+func isDatabaseURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// kebabCase returns the Go identifier s in kebab case as used for flag
+// names, e.g. DBUrl becomes db-url.
+func kebabCase(s string) string {
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				sb.WriteByte('-')
+			}
+		}
+		sb.WriteRune(unicode.ToLower(r))
+	}
+	return sb.String()
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lookupFunc resolves a hostname to a list of addresses, as used by pgconn to
+// resolve the database host.
+type lookupFunc func(ctx context.Context, host string) ([]string, error)
+
+// dnsCache caches the results of a lookupFunc for a fixed TTL, so that
+// frequently re-established database connections do not each wait on a DNS
+// lookup. Failed lookups are not cached.
+type dnsCache struct {
+	ttl    time.Duration
+	lookup lookupFunc
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// newDNSCache returns a dnsCache that caches the results of lookup for ttl.
+func newDNSCache(ttl time.Duration, lookup lookupFunc) *dnsCache {
+	return &dnsCache{
+		ttl:     ttl,
+		lookup:  lookup,
+		now:     time.Now,
+		entries: map[string]dnsCacheEntry{},
+	}
+}
+
+// lookupHost returns the addresses of host, from the cache if they were
+// looked up less than the TTL ago. It is a lookupFunc.
+func (c *dnsCache) lookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && c.now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := c.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsCacheEntry{addrs: addrs, expires: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return addrs, nil
+}
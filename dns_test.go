@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSCache(t *testing.T) {
+	lookups := 0
+	fail := false
+	resolver := func(ctx context.Context, host string) ([]string, error) {
+		lookups++
+		if fail {
+			return nil, errors.New("lookup failed")
+		}
+		return []string{"10.0.0.1"}, nil
+	}
+	now := mustParseTime("2021-01-01T00:00:00Z")
+	cache := newDNSCache(time.Minute, resolver)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		addrs, err := cache.lookupHost(ctx, "db.example.com")
+		require.NoError(t, err)
+		require.Equal(t, []string{"10.0.0.1"}, addrs)
+	}
+	require.Equal(t, 1, lookups)
+
+	_, err := cache.lookupHost(ctx, "other.example.com")
+	require.NoError(t, err)
+	require.Equal(t, 2, lookups)
+
+	now = now.Add(time.Minute)
+	_, err = cache.lookupHost(ctx, "db.example.com")
+	require.NoError(t, err)
+	require.Equal(t, 3, lookups)
+
+	now = now.Add(time.Minute)
+	fail = true
+	_, err = cache.lookupHost(ctx, "db.example.com")
+	require.Error(t, err)
+	_, err = cache.lookupHost(ctx, "db.example.com")
+	require.Error(t, err)
+	require.Equal(t, 5, lookups)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// dryRun parses every query in inputs without executing any, writing the
+// number of valid queries and the first parse error, if any, to w. Unlike
+// readCSV, it continues past invalid rows so the count covers all of the
+// inputs. It returns the first parse error.
+func dryRun(w io.Writer, config *CLI, inputs []io.Reader) error {
+	count := 0
+	var err error
+	for _, input := range inputs {
+		n, ierr := parseQueries(config, input)
+		count += n
+		if ierr != nil && err == nil {
+			err = nameInputError(input, len(inputs), ierr)
+		}
+	}
+	fmt.Fprintf(w, "Valid queries: %d\n", count)
+	if err != nil {
+		fmt.Fprintf(w, "First error: %v\n", err)
+	}
+	return err
+}
+
+// parseQueries returns the number of valid queries in input and the first
+// error parsing it. Errors in the CSV format carry the line of the file
+// while invalid queries carry the line of the row, as with readCSV. Only
+// malformed and invalid rows are skipped; any other error reading input,
+// such as a truncated gzip file, ends it.
+func parseQueries(config *CLI, input io.Reader) (int, error) {
+	r := newCSVReader(config, input)
+	cols, err := readCSVColumns(config, r)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	var first error
+	for line := 1; ; line++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return count, first
+		}
+		var pe *csv.ParseError
+		if err != nil && !errors.As(err, &pe) {
+			return count, err
+		}
+		if err == nil {
+			if _, err = newQuery(config, cols, row); err != nil {
+				err = fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		count++
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	err := dryRun(&buf, &CLI{}, []io.Reader{strings.NewReader(goodHeader + good1 + good2)})
+	require.NoError(t, err)
+	require.Equal(t, "Valid queries: 2\n", buf.String())
+
+	buf.Reset()
+	input := goodHeader + good1 + "host_000001,yesterday,2017-01-02 14:02:02\n" + good2 + ",2017-01-01 08:59:22,2017-01-01 09:59:22\n"
+	err = dryRun(&buf, &CLI{}, []io.Reader{strings.NewReader(input)})
+	require.Error(t, err)
+	require.Contains(t, buf.String(), "Valid queries: 2\nFirst error: line 2: invalid start time: yesterday: ")
+
+	buf.Reset()
+	err = dryRun(&buf, &CLI{}, []io.Reader{strings.NewReader(goodHeader + "host_000001\n" + good1)})
+	require.Error(t, err)
+	require.Contains(t, buf.String(), "Valid queries: 1\nFirst error: record on line 2: wrong number of fields\n")
+
+	buf.Reset()
+	err = dryRun(&buf, &CLI{NoHeader: true}, []io.Reader{strings.NewReader("host_000001,2017-01-02 13:02:02\n" + good1)})
+	require.Error(t, err)
+	require.Contains(t, buf.String(), "Valid queries: 1\nFirst error: record on line 1: wrong number of fields\n")
+}
+
+func TestDryRunTruncatedGzip(t *testing.T) {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, err := zw.Write([]byte(csvInput(1000)))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	zr, err := gzip.NewReader(bytes.NewReader(compressed.Bytes()[:compressed.Len()/2]))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = dryRun(&buf, &CLI{}, []io.Reader{zr})
+	require.True(t, errors.Is(err, io.ErrUnexpectedEOF), err)
+	require.Contains(t, buf.String(), "First error: unexpected EOF\n")
+}
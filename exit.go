@@ -0,0 +1,62 @@
+package main
+
+import "errors"
+
+// The exit statuses of a run. An error is mapped to its status by exitCode.
+const (
+	// exitFailure is the exit status of any other error, such as an
+	// invalid flag or an output file that cannot be written.
+	exitFailure = 1
+	// exitInput is the exit status if the input cannot be read or parsed.
+	exitInput = 2
+	// exitConnect is the exit status if the database cannot be connected
+	// to.
+	exitConnect = 3
+	// exitQuery is the exit status if a query fails.
+	exitQuery = 4
+	// exitRegression is the exit status if the run is slower than the
+	// baseline by more than the threshold.
+	exitRegression = 5
+	// exitInterrupted is the exit status of a run interrupted by a
+	// signal, as used by shells for SIGINT.
+	exitInterrupted = 130
+)
+
+// inputError is an error reading or parsing the input.
+type inputError struct{ err error }
+
+func (e *inputError) Error() string { return e.err.Error() }
+func (e *inputError) Unwrap() error { return e.err }
+
+// connectError is an error connecting to the database.
+type connectError struct{ err error }
+
+func (e *connectError) Error() string { return e.err.Error() }
+func (e *connectError) Unwrap() error { return e.err }
+
+// queryError is an error preparing or executing the benchmark query.
+type queryError struct{ err error }
+
+func (e *queryError) Error() string { return e.err.Error() }
+func (e *queryError) Unwrap() error { return e.err }
+
+// exitCode returns the exit status for err: exitInput for an inputError,
+// exitConnect for a connectError, exitQuery for a queryError and exitFailure
+// for any other error. It returns 0 if err is nil.
+func exitCode(err error) int {
+	var ie *inputError
+	var ce *connectError
+	var qe *queryError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &ie):
+		return exitInput
+	case errors.As(err, &ce):
+		return exitConnect
+	case errors.As(err, &qe):
+		return exitQuery
+	default:
+		return exitFailure
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExitCode(t *testing.T) {
+	require.Equal(t, 0, exitCode(nil))
+	require.Equal(t, exitFailure, exitCode(errors.New("failed")))
+	require.Equal(t, exitConnect, exitCode(fmt.Errorf("wrapped: %w", &connectError{errors.New("refused")})))
+
+	_, err := runPipeline(context.Background(), &CLI{Workers: 1}, []io.Reader{strings.NewReader(badHeader)}, &fakeExecutor{})
+	require.Equal(t, exitInput, exitCode(err))
+
+	exec := &fakeExecutor{fail: map[string]bool{"host_000008": true}}
+	_, err = runPipeline(context.Background(), &CLI{Workers: 1}, []io.Reader{strings.NewReader(goodHeader + good1)}, exec)
+	require.EqualError(t, err, "query failed for host_000008")
+	require.Equal(t, exitQuery, exitCode(err))
+}
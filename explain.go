@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// explainExecutor is a connExecutor that, after executing a query, executes
+// it again prefixed with EXPLAIN (ANALYZE, BUFFERS) and writes the plan to w.
+// Each distinct query is explained once, and only the first sample distinct
+// queries are explained, or all of them if sample is 0.
+//
+// The EXPLAIN is not included in the duration of the result, but it occupies
+// the worker and the database, so it lowers the throughput of the run.
+type explainExecutor struct {
+	connExecutor
+	sample  int
+	explain func(ctx context.Context, q query) ([]string, error)
+
+	mu        sync.Mutex
+	w         io.Writer
+	seen      map[queryKey]bool
+	explained int
+}
+
+// newExplainExecutor returns an explainExecutor for exec explaining the
+// benchmark SQL bsql on db.
+func newExplainExecutor(exec connExecutor, db queryer, bsql benchmarkSQL, w io.Writer, sample int) *explainExecutor {
+	return &explainExecutor{
+		connExecutor: exec,
+		sample:       sample,
+		explain: func(ctx context.Context, q query) ([]string, error) {
+			return explainPlan(ctx, db, bsql, q)
+		},
+		w:    w,
+		seen: map[queryKey]bool{},
+	}
+}
+
+func (e *explainExecutor) executeQuery(ctx context.Context, q query) (queryResult, error) {
+	qr, err := e.connExecutor.executeQuery(ctx, q)
+	if err != nil || qr.timedOut || !e.claim(q) {
+		return qr, err
+	}
+	plan, err := e.explain(ctx, q)
+	if err != nil {
+		return queryResult{}, fmt.Errorf("explain failed for %s: %w", q.hostname, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fmt.Fprintf(e.w, "-- %s %s - %s\n", q.hostname, q.start.Format(timeLayout), q.end.Format(timeLayout))
+	for _, line := range plan {
+		fmt.Fprintln(e.w, line)
+	}
+	fmt.Fprintln(e.w)
+	return qr, nil
+}
+
+// claim returns true if q is to be explained: it has not been explained
+// before and the sample is not yet complete.
+func (e *explainExecutor) claim(q query) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key := queryKey{q.hostname, q.start.UnixNano(), q.end.UnixNano()}
+	if e.seen[key] || e.sample > 0 && e.explained >= e.sample {
+		return false
+	}
+	e.seen[key] = true
+	e.explained++
+	return true
+}
+
+// explainPlan executes the benchmark SQL bsql for q on db prefixed with
+// EXPLAIN (ANALYZE, BUFFERS) and returns the lines of the plan.
+func explainPlan(ctx context.Context, db queryer, bsql benchmarkSQL, q query) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+bsql.text, bsql.args(q)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		plan = append(plan, line)
+	}
+	return plan, rows.Err()
+}
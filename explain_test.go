@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplainExecutor(t *testing.T) {
+	var buf strings.Builder
+	exec := newExplainExecutor(&closingExecutor{}, nil, benchmarkSQL{}, &buf, 2)
+	var explained []query
+	exec.explain = func(ctx context.Context, q query) ([]string, error) {
+		explained = append(explained, q)
+		return []string{"Index Scan on cpu_usage", "Execution Time: 0.1 ms"}, nil
+	}
+
+	otherHost := good1Query
+	otherHost.hostname = "host_000002"
+	for _, q := range []query{good1Query, good1Query, good2Query, otherHost} {
+		_, err := exec.executeQuery(context.Background(), q)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []query{good1Query, good2Query}, explained)
+	require.Equal(t, "-- host_000008 2017-01-01 08:59:22 - 2017-01-01 09:59:22\n"+
+		"Index Scan on cpu_usage\nExecution Time: 0.1 ms\n\n"+
+		"-- host_000001 2017-01-02 13:02:02 - 2017-01-02 14:02:02\n"+
+		"Index Scan on cpu_usage\nExecution Time: 0.1 ms\n\n", buf.String())
+}
+
+func TestValidateExplain(t *testing.T) {
+	config := &CLI{Workers: 1, Iterations: 1, Input: []*os.File{os.Stdin}, Explain: "plans.txt"}
+	require.NoError(t, config.Validate())
+	config.ConnectionPerHost, config.MaxHostConnections = true, 10
+	require.EqualError(t, config.Validate(), "--explain cannot be used with --connection-per-host")
+	config.ConnectionPerHost = false
+	config.ExplainSample = -1
+	require.Error(t, config.Validate())
+}
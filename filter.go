@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// limitQueries sends the queries on the input channel to the output channel
+// until max queries have been sent. It then calls stop to stop the sender on
+// the input channel and returns true. It returns false if the input channel
+// is closed or ctx is done before max queries are sent.
+func limitQueries(ctx context.Context, max int, stop func(), input <-chan query, output chan<- query) bool {
+	defer close(output)
+
+	sent := 0
+	var q query
+	for sent < max && recvQuery(ctx, &q, input) {
+		if !sendQuery(ctx, q, output) {
+			return false
+		}
+		sent++
+	}
+	if sent < max {
+		return false
+	}
+	stop()
+	return true
+}
+
+// markWarmup sends the queries on the input channel to the output channel,
+// marking the first n as warmup queries.
+func markWarmup(ctx context.Context, n int, input <-chan query, output chan<- query) {
+	defer close(output)
+
+	var q query
+	for i := 0; recvQuery(ctx, &q, input); i++ {
+		q.warmup = i < n
+		if !sendQuery(ctx, q, output) {
+			return
+		}
+	}
+}
+
+// collapseQueries sends the queries on the input channel to the output
+// channel, dropping any query whose window overlaps the window of an earlier
+// query sent for the same hostname by more than fraction. The overlap is
+// measured as the length of the intersection of the two windows over the
+// length of their union, so only windows that are nearly the same are
+// collapsed and coverage of the time range is preserved. It returns the
+// number of queries dropped.
+//
+// The windows of all queries sent are kept in memory to compare with later
+// queries, and each query is compared against all earlier queries for its
+// host, so this is best suited to inputs with a moderate number of queries
+// per host.
+func collapseQueries(ctx context.Context, fraction float64, input <-chan query, output chan<- query) int {
+	defer close(output)
+
+	kept := map[string][]query{}
+	collapsed := 0
+	var q query
+	for recvQuery(ctx, &q, input) {
+		if overlapsAny(q, kept[q.hostname], fraction) {
+			collapsed++
+			continue
+		}
+		kept[q.hostname] = append(kept[q.hostname], q)
+		if !sendQuery(ctx, q, output) {
+			break
+		}
+	}
+	return collapsed
+}
+
+// queryKey identifies a query by its hostname and window.
+type queryKey struct {
+	hostname   string
+	start, end int64
+}
+
+// dedupeQueries sends the queries on the input channel to the output channel,
+// dropping any query with the same hostname, start and end time as an earlier
+// query. It returns the number of queries dropped.
+//
+// The key of every distinct query is kept in memory, roughly 50 bytes plus
+// the length of the hostname each, so memory grows with the number of
+// distinct queries in the input.
+func dedupeQueries(ctx context.Context, input <-chan query, output chan<- query) int {
+	defer close(output)
+
+	seen := map[queryKey]struct{}{}
+	dropped := 0
+	var q query
+	for recvQuery(ctx, &q, input) {
+		key := queryKey{q.hostname, q.start.UnixNano(), q.end.UnixNano()}
+		if _, ok := seen[key]; ok {
+			dropped++
+			continue
+		}
+		seen[key] = struct{}{}
+		if !sendQuery(ctx, q, output) {
+			break
+		}
+	}
+	return dropped
+}
+
+// sortQueries reads all the queries on the input channel, then sends them to
+// the output channel sorted by hostname and start time. Queries with the same
+// hostname and start time keep their input order.
+//
+// No query is sent until the input channel is closed, and every query is
+// kept in memory until then, about 100 bytes plus the hostname each.
+func sortQueries(ctx context.Context, input <-chan query, output chan<- query) {
+	defer close(output)
+
+	var queries []query
+	var q query
+	for recvQuery(ctx, &q, input) {
+		queries = append(queries, q)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	sort.SliceStable(queries, func(i, j int) bool {
+		a, b := queries[i], queries[j]
+		if a.hostname != b.hostname {
+			return a.hostname < b.hostname
+		}
+		return a.start.Before(b.start)
+	})
+	for _, q := range queries {
+		if !sendQuery(ctx, q, output) {
+			return
+		}
+	}
+}
+
+// checkOverlaps sends the queries on the input channel to the output channel
+// unchanged, writing a warning to w for each query whose window overlaps the
+// window of an earlier query for the same hostname. It returns the number of
+// overlapping queries.
+//
+// Like collapseQueries, the windows of all queries are kept in memory and
+// each query is compared against all earlier queries for its host.
+func checkOverlaps(ctx context.Context, w io.Writer, input <-chan query, output chan<- query) int {
+	defer close(output)
+
+	seen := map[string][]query{}
+	overlaps := 0
+	var q query
+	for recvQuery(ctx, &q, input) {
+		for _, other := range seen[q.hostname] {
+			if overlap(q, other) > 0 {
+				overlaps++
+				fmt.Fprintf(w, "warning: window %s - %s for %s overlaps earlier window %s - %s\n",
+					q.start.Format(timeLayout), q.end.Format(timeLayout), q.hostname,
+					other.start.Format(timeLayout), other.end.Format(timeLayout))
+				break
+			}
+		}
+		seen[q.hostname] = append(seen[q.hostname], q)
+		if !sendQuery(ctx, q, output) {
+			break
+		}
+	}
+	return overlaps
+}
+
+// overlapsAny returns true if the window of q overlaps the window of any of
+// queries by more than fraction of their union.
+func overlapsAny(q query, queries []query, fraction float64) bool {
+	for _, other := range queries {
+		if overlap(q, other) > fraction {
+			return true
+		}
+	}
+	return false
+}
+
+// overlap returns the length of the intersection of the windows of a and b
+// as a fraction of the length of their union. Identical windows, including
+// zero length ones, overlap by 1.
+func overlap(a, b query) float64 {
+	if a.start.Equal(b.start) && a.end.Equal(b.end) {
+		return 1
+	}
+	start, end := maxTime(a.start, b.start), minTime(a.end, b.end)
+	if !end.After(start) {
+		return 0
+	}
+	union := maxTime(a.end, b.end).Sub(minTime(a.start, b.start))
+	return float64(end.Sub(start)) / float64(union)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
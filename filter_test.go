@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// filter is a helper function that sends queries through the filter stage f
+// and returns the queries it outputs.
+func filter(f func(ctx context.Context, input <-chan query, output chan<- query), queries ...query) []query {
+	input := make(chan query)
+	output := make(chan query)
+	go func() {
+		for _, q := range queries {
+			input <- q
+		}
+		close(input)
+	}()
+	go f(context.Background(), input, output)
+	return collect(output)
+}
+
+func TestCollapseQueries(t *testing.T) {
+	base := good1Query // 08:59:22 - 09:59:22
+	shifted := base    // overlaps base by 54/66 minutes
+	shifted.start = base.start.Add(6 * time.Minute)
+	shifted.end = base.end.Add(6 * time.Minute)
+	later := base // overlaps base by 30/90 minutes
+	later.start = base.start.Add(30 * time.Minute)
+	later.end = base.end.Add(30 * time.Minute)
+	otherHost := base
+	otherHost.hostname = "host_000002"
+
+	collapsed := make(chan int, 1)
+	collapse := func(ctx context.Context, input <-chan query, output chan<- query) {
+		collapsed <- collapseQueries(ctx, 0.8, input, output)
+	}
+	got := filter(collapse, base, shifted, base, later, otherHost, good2Query)
+	require.Equal(t, []query{base, later, otherHost, good2Query}, got)
+	require.Equal(t, 2, <-collapsed)
+
+	require.InDelta(t, 54.0/66.0, overlap(base, shifted), 1e-9)
+	require.InDelta(t, 30.0/90.0, overlap(base, later), 1e-9)
+	require.Equal(t, 0.0, overlap(base, good2Query))
+}
+
+func TestDedupeQueries(t *testing.T) {
+	shifted := good1Query
+	shifted.end = shifted.end.Add(time.Minute)
+	otherHost := good1Query
+	otherHost.hostname = "host_000002"
+
+	dropped := make(chan int, 1)
+	dedupe := func(ctx context.Context, input <-chan query, output chan<- query) {
+		dropped <- dedupeQueries(ctx, input, output)
+	}
+	got := filter(dedupe, good1Query, good2Query, good1Query, shifted, otherHost, good2Query)
+	require.Equal(t, []query{good1Query, good2Query, shifted, otherHost}, got)
+	require.Equal(t, 2, <-dropped)
+}
+
+func TestRunPipelineDedupe(t *testing.T) {
+	input := goodHeader + good1 + good2 + good1 + good1
+	summary, err := runPipeline(context.Background(), &CLI{Workers: 1, Dedupe: true}, []io.Reader{strings.NewReader(input)}, &fakeExecutor{})
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.count)
+	require.Equal(t, 2, summary.duplicates)
+}
+
+func TestRunPipelineResultDedupWindow(t *testing.T) {
+	input := goodHeader + good1 + good2 + good1 + good1
+	exec := &fakeExecutor{}
+	config := &CLI{Workers: 1, ResultDedupWindow: 0.5}
+	summary, err := runPipeline(context.Background(), config, []io.Reader{strings.NewReader(input)}, exec)
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.count)
+	require.Equal(t, 2, summary.collapsed)
+}
+
+func TestCheckOverlaps(t *testing.T) {
+	shifted := good1Query
+	shifted.start = shifted.start.Add(30 * time.Minute)
+	shifted.end = shifted.end.Add(30 * time.Minute)
+	adjacent := good1Query
+	adjacent.start, adjacent.end = good1Query.end, good1Query.end.Add(time.Hour)
+	otherHost := good1Query
+	otherHost.hostname = "host_000002"
+
+	var buf strings.Builder
+	overlaps := make(chan int, 1)
+	check := func(ctx context.Context, input <-chan query, output chan<- query) {
+		overlaps <- checkOverlaps(ctx, &buf, input, output)
+	}
+	got := filter(check, good1Query, adjacent, otherHost, shifted, good2Query)
+	require.Equal(t, []query{good1Query, adjacent, otherHost, shifted, good2Query}, got)
+	require.Equal(t, 1, <-overlaps)
+	require.Equal(t, "warning: window 2017-01-01 09:29:22 - 2017-01-01 10:29:22 for host_000008 overlaps earlier window 2017-01-01 08:59:22 - 2017-01-01 09:59:22\n", buf.String())
+}
+
+func TestRunPipelineCheckOverlap(t *testing.T) {
+	input := goodHeader + good1 + good2 + good1
+	summary, err := runPipeline(context.Background(), &CLI{Workers: 1, CheckOverlap: true}, []io.Reader{strings.NewReader(input)}, &fakeExecutor{})
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.count)
+	require.Equal(t, 1, summary.overlaps)
+}
+
+func TestSortQueries(t *testing.T) {
+	later := good1Query
+	later.start = later.start.Add(time.Hour)
+	otherHost := later
+	otherHost.hostname = "host_000002"
+
+	got := filter(sortQueries, later, good2Query, good1Query, otherHost)
+	require.Equal(t, []query{good2Query, otherHost, good1Query, later}, got)
+}
+
+func TestRunPipelineSort(t *testing.T) {
+	input := goodHeader + good1 + good2 + good1
+	exec := &fakeExecutor{}
+	summary, err := runPipeline(context.Background(), &CLI{Workers: 1, Sort: true}, []io.Reader{strings.NewReader(input)}, exec)
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.count)
+	require.Equal(t, []query{good2Query, good1Query, good1Query}, exec.executed)
+}
+
+func TestMarkWarmup(t *testing.T) {
+	got := filter(func(ctx context.Context, input <-chan query, output chan<- query) {
+		markWarmup(ctx, 2, input, output)
+	}, good1Query, good2Query, good1Query)
+	require.Len(t, got, 3)
+	require.True(t, got[0].warmup)
+	require.True(t, got[1].warmup)
+	require.False(t, got[2].warmup)
+}
+
+func TestRunPipelineWarmup(t *testing.T) {
+	input := goodHeader + strings.Repeat(good1+good2, 3)
+	exec := &fakeExecutor{duration: time.Millisecond}
+	config := &CLI{Workers: 2, Warmup: 2}
+	summary, err := runPipeline(context.Background(), config, []io.Reader{strings.NewReader(input)}, exec)
+	require.NoError(t, err)
+	require.Equal(t, 4, summary.count)
+	require.Equal(t, 2, summary.warmups)
+	require.Len(t, exec.executed, 6)
+}
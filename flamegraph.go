@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// queryPhases are the phases of executing a query, in order.
+var queryPhases = []string{"execute", "fetch", "scan"}
+
+// phaseDurations returns the time qr spent in each of queryPhases:
+//
+//	execute: until the database accepted the query, including waiting for a
+//	  connection and preparing the statement on it if needed
+//	fetch: from then until the first row was available
+//	scan: reading the rest of the result
+func phaseDurations(qr queryResult) []time.Duration {
+	execute := qr.executeDuration
+	firstRow := qr.firstRowDuration
+	if firstRow < execute {
+		firstRow = execute
+	}
+	return []time.Duration{execute, firstRow - execute, qr.queryDuration - firstRow}
+}
+
+// writeFlamegraph sends each query result on the input channel to the output
+// channel, adding up the time the executed results spent in each phase for
+// each host. Once all results are received, the totals are written to w in
+// microseconds in the collapsed stack format read by flamegraph tools:
+//
+//	tsbench;host_000008;execute 1234
+//
+// Skipped and warmup results are passed on but not counted.
+func writeFlamegraph(ctx context.Context, w io.Writer, input <-chan queryResult, output chan<- queryResult) error {
+	defer close(output)
+
+	totals := map[string][]time.Duration{}
+	var qr queryResult
+	for recvQueryResult(ctx, &qr, input) {
+		if qr.executed() {
+			t, ok := totals[qr.query.hostname]
+			if !ok {
+				t = make([]time.Duration, len(queryPhases))
+				totals[qr.query.hostname] = t
+			}
+			for i, d := range phaseDurations(qr) {
+				t[i] += d
+			}
+		}
+		if !sendQueryResult(ctx, qr, output) {
+			break
+		}
+	}
+
+	hosts := make([]string, 0, len(totals))
+	for h := range totals {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	bw := bufio.NewWriter(w)
+	for _, h := range hosts {
+		for i, phase := range queryPhases {
+			fmt.Fprintf(bw, "tsbench;%s;%s %d\n", h, phase, totals[h][i].Microseconds())
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFlamegraph(t *testing.T) {
+	input := make(chan queryResult)
+	output := make(chan queryResult)
+	var buf bytes.Buffer
+	errc := make(chan error)
+	go func() { errc <- writeFlamegraph(context.Background(), &buf, input, output) }()
+
+	results := []queryResult{
+		{query: good1Query, executeDuration: 100 * time.Microsecond, firstRowDuration: 300 * time.Microsecond, queryDuration: 1000 * time.Microsecond},
+		{query: good2Query, executeDuration: 50 * time.Microsecond, firstRowDuration: 60 * time.Microsecond, queryDuration: 70 * time.Microsecond},
+		{query: good1Query, executeDuration: 10 * time.Microsecond, firstRowDuration: 20 * time.Microsecond, queryDuration: 30 * time.Microsecond},
+		{query: good2Query, skipped: true},
+	}
+	for _, qr := range results {
+		input <- qr
+		<-output
+	}
+	close(input)
+	_, ok := <-output
+	require.False(t, ok)
+	require.NoError(t, <-errc)
+
+	want := "tsbench;host_000001;execute 50\n" +
+		"tsbench;host_000001;fetch 10\n" +
+		"tsbench;host_000001;scan 10\n" +
+		"tsbench;host_000008;execute 110\n" +
+		"tsbench;host_000008;fetch 210\n" +
+		"tsbench;host_000008;scan 710\n"
+	require.Equal(t, want, buf.String())
+}
@@ -4,6 +4,8 @@ go 1.15
 
 require (
 	github.com/alecthomas/kong v0.2.12
+	github.com/jackc/pgconn v1.8.0
+	github.com/jackc/pgpassfile v1.0.0
 	github.com/jackc/pgx v3.6.2+incompatible
 	github.com/jackc/pgx/v4 v4.10.1
 	github.com/lib/pq v1.3.0
@@ -11,4 +13,5 @@ require (
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a
 	golang.org/x/text v0.3.5 // indirect
+	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324
 )
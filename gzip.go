@@ -0,0 +1,74 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// inputReaders returns a reader of each input file of config, in order.
+// Errors are returned as an inputError.
+func inputReaders(config *CLI) ([]io.Reader, error) {
+	inputs := make([]io.Reader, 0, len(config.Input))
+	for _, f := range config.Input {
+		r, err := inputReader(config, f)
+		if err != nil {
+			return nil, &inputError{fmt.Errorf("%s: %w", f.Name(), err)}
+		}
+		inputs = append(inputs, r)
+	}
+	return inputs, nil
+}
+
+// inputReader returns a reader of the input file f. If config.Gzip is set or
+// the file name ends in .gz, the file is decompressed as it is read.
+func inputReader(config *CLI, f *os.File) (io.Reader, error) {
+	if !config.Gzip && !strings.HasSuffix(f.Name(), ".gz") {
+		return f, nil
+	}
+	return newGzipInput(f)
+}
+
+// gzipInput reads a gzip-compressed file decompressed. It can seek only to
+// the start, by seeking the file and restarting the decompression, so that
+// it can be read more than once for iterations. With --reopen-input, rewind
+// replaces it with a gzipInput of the reopened file instead.
+type gzipInput struct {
+	f  *os.File
+	zr *gzip.Reader
+}
+
+func newGzipInput(f *os.File) (*gzipInput, error) {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipInput{f: f, zr: zr}, nil
+}
+
+func (g *gzipInput) Read(p []byte) (int, error) {
+	return g.zr.Read(p)
+}
+
+func (g *gzipInput) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("gzip input can only seek to the start")
+	}
+	if _, err := g.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return 0, g.zr.Reset(g.f)
+}
+
+// Name returns the name of the compressed file.
+func (g *gzipInput) Name() string {
+	return g.f.Name()
+}
+
+func (g *gzipInput) Close() error {
+	g.zr.Close()
+	return g.f.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// openGzip writes data gzip-compressed to a temporary .gz file and opens it.
+func openGzip(t *testing.T, data string) *os.File {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "queries.csv.gz")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	zw := gzip.NewWriter(f)
+	_, err = zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	f, err = os.Open(filename)
+	require.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestGzipInput(t *testing.T) {
+	config := &CLI{Input: []*os.File{openGzip(t, goodHeader+good1+good2)}, Workers: 1, Iterations: 2}
+	inputs, err := inputReaders(config)
+	require.NoError(t, err)
+	summary, err := runPipeline(context.Background(), config, inputs, &fakeExecutor{duration: time.Millisecond})
+	require.NoError(t, err)
+	require.Equal(t, 4, summary.count)
+
+	// Without the .gz extension, the input is only decompressed with Gzip.
+	input, err := inputReader(config, os.Stdin)
+	require.NoError(t, err)
+	require.Equal(t, os.Stdin, input)
+}
+
+func TestGzipInputReopen(t *testing.T) {
+	config := &CLI{Input: []*os.File{openGzip(t, goodHeader+good1)}, Workers: 1, Iterations: 3, ReopenInput: true}
+	inputs, err := inputReaders(config)
+	require.NoError(t, err)
+	summary, err := runPipeline(context.Background(), config, inputs, &fakeExecutor{duration: time.Millisecond})
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.count)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// histogramBarWidth is the width in characters of the longest bar of a
+// printed histogram.
+const histogramBarWidth = 40
+
+// histogramBucket is a bucket of a histogram of query durations, counting
+// the durations from lower up to upper. The last bucket includes upper.
+type histogramBucket struct {
+	lower, upper time.Duration
+	count        int
+}
+
+// durationHistogram counts the query durations of results in n buckets
+// spanning the shortest to the longest duration. If scale is "log", the
+// bucket bounds grow geometrically, giving more detail to short durations,
+// otherwise the buckets are of equal width. It returns no buckets if there
+// are no results.
+func durationHistogram(results []queryResult, n int, scale string) []histogramBucket {
+	if len(results) == 0 || n <= 0 {
+		return nil
+	}
+	min, max := results[0].queryDuration, results[0].queryDuration
+	for _, qr := range results {
+		if qr.queryDuration < min {
+			min = qr.queryDuration
+		}
+		if qr.queryDuration > max {
+			max = qr.queryDuration
+		}
+	}
+	if min == max {
+		return []histogramBucket{{lower: min, upper: max, count: len(results)}}
+	}
+
+	buckets := make([]histogramBucket, n)
+	for i := range buckets {
+		buckets[i].lower = histogramBound(min, max, i, n, scale)
+		buckets[i].upper = histogramBound(min, max, i+1, n, scale)
+	}
+	for _, qr := range results {
+		i := 0
+		for i < n-1 && qr.queryDuration >= buckets[i].upper {
+			i++
+		}
+		buckets[i].count++
+	}
+	return buckets
+}
+
+// histogramBound returns the i-th of the n+1 bucket bounds from min to max.
+func histogramBound(min, max time.Duration, i, n int, scale string) time.Duration {
+	if i == n {
+		return max
+	}
+	if scale == "log" {
+		lo := math.Max(float64(min), float64(time.Microsecond))
+		return time.Duration(lo * math.Pow(float64(max)/lo, float64(i)/float64(n)))
+	}
+	return min + (max-min)*time.Duration(i)/time.Duration(n)
+}
+
+// printHistogram writes buckets to w as a bar chart, one line per bucket
+// with its bounds, formatted by f, a bar proportional to its count, and its
+// count.
+func printHistogram(w io.Writer, buckets []histogramBucket, f durationFormat) {
+	if len(buckets) == 0 {
+		fmt.Fprintln(w, "Histogram: no results")
+		return
+	}
+	most := 0
+	for _, b := range buckets {
+		if b.count > most {
+			most = b.count
+		}
+	}
+	fmt.Fprintln(w, "Histogram of processing times:")
+	for _, b := range buckets {
+		bar := strings.Repeat("#", b.count*histogramBarWidth/most)
+		fmt.Fprintf(w, "  %9v - %-9v |%-*s| %d\n", f.formatRounded(b.lower), f.formatRounded(b.upper), histogramBarWidth, bar, b.count)
+	}
+}
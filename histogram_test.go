@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurationHistogram(t *testing.T) {
+	var results []queryResult
+	for _, ms := range []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 10, 100} {
+		results = append(results, queryResult{queryDuration: time.Duration(ms) * time.Millisecond})
+	}
+
+	linear := durationHistogram(results, 3, "linear")
+	require.Equal(t, []histogramBucket{
+		{lower: time.Millisecond, upper: 34 * time.Millisecond, count: 11},
+		{lower: 34 * time.Millisecond, upper: 67 * time.Millisecond, count: 0},
+		{lower: 67 * time.Millisecond, upper: 100 * time.Millisecond, count: 1},
+	}, linear)
+
+	log := durationHistogram(results, 2, "log")
+	require.Equal(t, []histogramBucket{
+		{lower: time.Millisecond, upper: 10 * time.Millisecond, count: 9},
+		{lower: 10 * time.Millisecond, upper: 100 * time.Millisecond, count: 3},
+	}, log)
+
+	same := durationHistogram(results[:1], 10, "log")
+	require.Equal(t, []histogramBucket{{lower: time.Millisecond, upper: time.Millisecond, count: 1}}, same)
+
+	require.Empty(t, durationHistogram(nil, 10, "log"))
+}
+
+func TestPrintHistogram(t *testing.T) {
+	var buf bytes.Buffer
+	printHistogram(&buf, []histogramBucket{
+		{lower: time.Millisecond, upper: 10 * time.Millisecond, count: 4},
+		{lower: 10 * time.Millisecond, upper: 100 * time.Millisecond, count: 1},
+	}, durationFormat{})
+	want := "Histogram of processing times:\n" +
+		"        1ms - 10ms      |########################################| 4\n" +
+		"       10ms - 100ms     |##########                              | 1\n"
+	require.Equal(t, want, buf.String())
+
+	buf.Reset()
+	printHistogram(&buf, nil, durationFormat{})
+	require.Equal(t, "Histogram: no results\n", buf.String())
+
+	buf.Reset()
+	printHistogram(&buf, []histogramBucket{{lower: time.Millisecond, upper: 10 * time.Millisecond, count: 1}}, newDurationFormat("us", 0))
+	require.Equal(t, "Histogram of processing times:\n     1000µs - 10000µs   |########################################| 1\n", buf.String())
+}
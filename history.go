@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// historyRecord is a single line in the history file: the JSON summary of a
+// run along with when it ran and an optional tag identifying it.
+type historyRecord struct {
+	Timestamp time.Time `json:"timestamp"`
+	Tag       string    `json:"tag,omitempty"`
+	jsonSummary
+}
+
+// appendHistory appends summary as a single line of JSON to the history file
+// at filename, creating the file if it does not exist. Each line records the
+// time of the run and tag so that a series of runs can be compared over time.
+func appendHistory(filename, tag string, timestamp time.Time, summary querySummary) (err error) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	record := historyRecord{
+		Timestamp:   timestamp.UTC(),
+		Tag:         tag,
+		jsonSummary: newJSONSummary(summary),
+	}
+	return json.NewEncoder(f).Encode(record)
+}
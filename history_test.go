@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendHistory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.jsonl")
+	summary := querySummary{
+		count:  2,
+		sum:    3 * time.Millisecond,
+		min:    time.Millisecond,
+		max:    2 * time.Millisecond,
+		mean:   1500 * time.Microsecond,
+		median: 1500 * time.Microsecond,
+	}
+	ts1 := mustParseTime("2021-01-01T10:00:00Z")
+	ts2 := mustParseTime("2021-01-02T10:00:00Z")
+
+	require.NoError(t, appendHistory(filename, "", ts1, summary))
+	require.NoError(t, appendHistory(filename, "nightly", ts2, summary))
+
+	f, err := os.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lines, 2)
+
+	require.Equal(t, "2021-01-01T10:00:00Z", lines[0]["timestamp"])
+	require.NotContains(t, lines[0], "tag")
+	require.Equal(t, "2021-01-02T10:00:00Z", lines[1]["timestamp"])
+	require.Equal(t, "nightly", lines[1]["tag"])
+	require.Equal(t, float64(2), lines[1]["count"])
+	require.Equal(t, float64(3000000), lines[1]["sum_ns"])
+	require.Equal(t, float64(1500000), lines[1]["median_ns"])
+	require.NotContains(t, lines[1], "config")
+}
+
+func TestAppendHistoryConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.jsonl")
+	config := &CLI{Host: "db.example.com", Password: "s3cret", Workers: 2}
+	summary := querySummary{count: 1, config: configDump(config)}
+	require.NoError(t, appendHistory(filename, "", mustParseTime("2021-01-01T10:00:00Z"), summary))
+
+	b, err := ioutil.ReadFile(filename)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "s3cret")
+	var line struct {
+		Config map[string]interface{} `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal(b, &line))
+	require.Equal(t, "db.example.com", line.Config["host"])
+	require.Equal(t, "REDACTED", line.Config["password"])
+	require.Equal(t, float64(2), line.Config["workers"])
+}
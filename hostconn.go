@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// connExecutor is a queryExecutor that holds resources, such as a prepared
+// statement or connection, that must be released with close.
+type connExecutor interface {
+	queryExecutor
+	close() error
+}
+
+// hostConnExecutor is a queryExecutor that executes the queries for each
+// distinct hostname on a dedicated connection, so that the timing of one
+// host is not affected by queries for other hosts on the same connection.
+// At most max connections are opened; once they are, further hostnames
+// share the existing connections in turn.
+type hostConnExecutor struct {
+	open func(ctx context.Context) (connExecutor, error)
+	max  int
+
+	mu    sync.Mutex
+	hosts map[string]*hostConn
+	conns []*hostConn
+}
+
+// hostConn is a connection of a hostConnExecutor. Queries on it are
+// serialised, as a connection can only execute one query at a time.
+type hostConn struct {
+	mu   sync.Mutex
+	exec connExecutor
+}
+
+// newHostConnExecutor returns a hostConnExecutor that opens up to max
+// connections with open.
+func newHostConnExecutor(max int, open func(ctx context.Context) (connExecutor, error)) *hostConnExecutor {
+	return &hostConnExecutor{open: open, max: max, hosts: map[string]*hostConn{}}
+}
+
+func (e *hostConnExecutor) executeQuery(ctx context.Context, q query) (queryResult, error) {
+	hc, err := e.conn(ctx, q.hostname)
+	if err != nil {
+		return queryResult{}, err
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.exec.executeQuery(ctx, q)
+}
+
+// conn returns the connection for hostname, opening a new one if hostname
+// has none and fewer than max connections are open.
+func (e *hostConnExecutor) conn(ctx context.Context, hostname string) (*hostConn, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if hc, ok := e.hosts[hostname]; ok {
+		return hc, nil
+	}
+	var hc *hostConn
+	if len(e.conns) < e.max {
+		exec, err := e.open(ctx)
+		if err != nil {
+			return nil, err
+		}
+		hc = &hostConn{exec: exec}
+		e.conns = append(e.conns, hc)
+	} else {
+		hc = e.conns[len(e.hosts)%len(e.conns)]
+	}
+	e.hosts[hostname] = hc
+	return hc, nil
+}
+
+// close closes all the connections of e, returning the first error.
+func (e *hostConnExecutor) close() error {
+	var err error
+	for _, hc := range e.conns {
+		if cerr := hc.exec.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// connStmtExecutor is a stmtExecutor with its statement prepared on a
+// dedicated connection.
+type connStmtExecutor struct {
+	*stmtExecutor
+	conn *sql.Conn
+}
+
+// newConnStmtExecutor takes a connection from db and prepares the benchmark
+// query for config on it.
+func newConnStmtExecutor(ctx context.Context, db *sql.DB, config *CLI) (*connStmtExecutor, error) {
+	bsql, err := querySQL(config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.PrepareContext(ctx, bsql.text)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &connStmtExecutor{stmtExecutor: &stmtExecutor{stmt: stmt, bsql: bsql, db: conn}, conn: conn}, nil
+}
+
+func (e *connStmtExecutor) close() error {
+	err := e.stmt.Close()
+	if cerr := e.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// closingExecutor is a fakeExecutor that records whether it was closed.
+type closingExecutor struct {
+	fakeExecutor
+	closed bool
+}
+
+func (e *closingExecutor) close() error {
+	e.closed = true
+	return nil
+}
+
+func TestHostConnExecutor(t *testing.T) {
+	var opened []*closingExecutor
+	open := func(ctx context.Context) (connExecutor, error) {
+		e := &closingExecutor{}
+		opened = append(opened, e)
+		return e, nil
+	}
+	exec := newHostConnExecutor(2, open)
+
+	q := func(hostname string) query {
+		q := good1Query
+		q.hostname = hostname
+		return q
+	}
+	for _, h := range []string{"host_a", "host_b", "host_a", "host_c", "host_d", "host_c"} {
+		_, err := exec.executeQuery(context.Background(), q(h))
+		require.NoError(t, err)
+	}
+
+	// host_a and host_b each get a connection, then the cap is reached
+	// and host_c and host_d share them in turn.
+	require.Len(t, opened, 2)
+	require.Equal(t, []query{q("host_a"), q("host_a"), q("host_c"), q("host_c")}, opened[0].executed)
+	require.Equal(t, []query{q("host_b"), q("host_d")}, opened[1].executed)
+
+	require.NoError(t, exec.close())
+	require.True(t, opened[0].closed)
+	require.True(t, opened[1].closed)
+}
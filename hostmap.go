@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadHostnameMap reads a mapping of input hostnames to database hostnames
+// from filename. A file with a .json extension holds a JSON object of
+// hostname to database hostname. Any other file is a CSV file with rows of
+// two columns, the hostname and the database hostname, and no header.
+func loadHostnameMap(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var m map[string]string
+	if strings.EqualFold(filepath.Ext(filename), ".json") {
+		m, err = readHostnameMapJSON(f)
+	} else {
+		m, err = readHostnameMapCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid hostname map %s: %w", filename, err)
+	}
+	return m, nil
+}
+
+func readHostnameMapJSON(r io.Reader) (map[string]string, error) {
+	m := map[string]string{}
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func readHostnameMapCSV(r io.Reader) (map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	m := map[string]string{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return m, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[row[0]] = row[1]
+	}
+}
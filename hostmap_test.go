@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadHostnameMap(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "hosts.csv")
+	require.NoError(t, ioutil.WriteFile(csvFile, []byte("web-1,host_000008\nweb-2,host_000001\n"), 0o600))
+	m, err := loadHostnameMap(csvFile)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"web-1": "host_000008", "web-2": "host_000001"}, m)
+
+	jsonFile := filepath.Join(dir, "hosts.json")
+	require.NoError(t, ioutil.WriteFile(jsonFile, []byte(`{"web-1": "host_000008"}`), 0o600))
+	m, err = loadHostnameMap(jsonFile)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"web-1": "host_000008"}, m)
+
+	require.NoError(t, ioutil.WriteFile(csvFile, []byte("web-1\n"), 0o600))
+	_, err = loadHostnameMap(csvFile)
+	require.Error(t, err)
+}
+
+func TestReadQueriesHostnameMap(t *testing.T) {
+	input := goodHeader + "web-1,2017-01-01 08:59:22,2017-01-01 09:59:22\n" +
+		"host_000001,2017-01-02 13:02:02,2017-01-02 14:02:02\n"
+	config := &CLI{hostnameMap: map[string]string{"web-1": "host_000008"}}
+	got, err := parseWith(config, input)
+	require.NoError(t, err)
+	require.Equal(t, "host_000008", got[0].hostname)
+	require.Equal(t, "host_000001", got[1].hostname)
+
+	config.HostnameMapStrict = true
+	got, err = parseWith(config, input)
+	require.EqualError(t, err, "line 2: invalid hostname: host_000001: not in the hostname map")
+	require.Len(t, got, 1)
+}
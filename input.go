@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/alecthomas/kong"
+)
+
+// inputMapper is a kong.Mapper that opens the input files with openInput.
+// As with the kong file mapper, "-" is stdin.
+var inputMapper = kong.MapperFunc(func(ctx *kong.DecodeContext, target reflect.Value) error {
+	var path string
+	if err := ctx.Scan.PopValueInto("file", &path); err != nil {
+		return err
+	}
+	if path == "-" {
+		target.Set(reflect.ValueOf(os.Stdin))
+		return nil
+	}
+	f, err := openInput(kong.ExpandPath(path))
+	if err != nil {
+		return err
+	}
+	target.Set(reflect.ValueOf(f))
+	return nil
+})
+
+// openInput opens the input file path, returning a clear error if it does
+// not exist, cannot be read or is a directory. Other files that are not
+// regular files, such as named pipes, are allowed.
+func openInput(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("input %s does not exist", path)
+	case os.IsPermission(err):
+		return nil, fmt.Errorf("input %s is not readable: permission denied", path)
+	case err != nil:
+		return nil, fmt.Errorf("input %s is not readable: %w", path, err)
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = errors.New("it is a directory")
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("input %s is not a readable file: %w", path, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenInput(t *testing.T) {
+	dir := t.TempDir()
+	_, err := openInput(dir)
+	require.EqualError(t, err, "input "+dir+" is not a readable file: it is a directory")
+
+	missing := filepath.Join(dir, "missing.csv")
+	_, err = openInput(missing)
+	require.EqualError(t, err, "input "+missing+" does not exist")
+
+	cli := &CLI{}
+	parser, err := kong.New(cli, kong.TypeMapper(reflect.TypeOf(&os.File{}), inputMapper))
+	require.NoError(t, err)
+	_, err = parser.Parse([]string{"testdata/query_params.csv", "-"})
+	require.NoError(t, err)
+	require.Len(t, cli.Input, 2)
+	require.Equal(t, "query_params.csv", filepath.Base(cli.Input[0].Name()))
+	require.Equal(t, os.Stdin, cli.Input[1])
+	cli.Input[0].Close()
+
+	_, err = parser.Parse([]string{dir})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "it is a directory")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// jsonSummary is the JSON representation of a querySummary. All durations
+// are integer nanoseconds so they can be consumed reliably by other tools.
+type jsonSummary struct {
+	Count       int   `json:"count"`
+	Sum         int64 `json:"sum_ns"`
+	Min         int64 `json:"min_ns"`
+	Max         int64 `json:"max_ns"`
+	Mean        int64 `json:"mean_ns"`
+	GeoMean     int64 `json:"geomean_ns"`
+	StdDev      int64 `json:"stddev_ns"`
+	Median      int64 `json:"median_ns"`
+	P95         int64 `json:"p95_ns"`
+	P99         int64 `json:"p99_ns"`
+	SLOBreaches *int  `json:"slo_breaches,omitempty"`
+
+	// Checksum is the checksum of the data returned, if computed.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Throughput is the number of queries executed per second.
+	Throughput float64 `json:"throughput_qps"`
+
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// newJSONSummary returns the JSON representation of summary. The SLO breach
+// count is only included if any query had an expected duration, and the
+// configuration only if it was recorded in summary.
+func newJSONSummary(summary querySummary) jsonSummary {
+	js := jsonSummary{
+		Count:   summary.count,
+		Sum:     int64(summary.sum),
+		Min:     int64(summary.min),
+		Max:     int64(summary.max),
+		Mean:    int64(summary.mean),
+		GeoMean: int64(summary.geomean),
+		StdDev:  int64(summary.stddev),
+		Median:  int64(summary.median),
+		P95:     int64(summary.p95),
+		P99:     int64(summary.p99),
+
+		Checksum:   summary.checksum,
+		Throughput: summary.throughput,
+		Config:     summary.config,
+	}
+	if summary.sloChecked {
+		breaches := len(summary.sloBreaches)
+		js.SLOBreaches = &breaches
+	}
+	return js
+}
+
+// printJSON writes summary to w as a JSON object on a single line. If
+// fields is not empty, only the summary fields named in it are written.
+func printJSON(w io.Writer, summary querySummary, fields []string) error {
+	if len(fields) > 0 {
+		return json.NewEncoder(w).Encode(jsonFields(summary, fields))
+	}
+	return json.NewEncoder(w).Encode(newJSONSummary(summary))
+}
+
+// jsonFields returns the summary fields named in fields, keyed as in
+// jsonSummary: durations are integer nanoseconds with an _ns suffix on the
+// name of the field.
+func jsonFields(summary querySummary, fields []string) map[string]interface{} {
+	selected := map[string]bool{}
+	for _, f := range fields {
+		selected[f] = true
+	}
+	js := map[string]interface{}{}
+	for _, sf := range summaryFields {
+		if !selected[sf.name] {
+			continue
+		}
+		switch v := sf.value(summary).(type) {
+		case time.Duration:
+			js[sf.name+"_ns"] = int64(v)
+		default:
+			if sf.name == "throughput" {
+				js["throughput_qps"] = v
+				continue
+			}
+			js[sf.name] = v
+		}
+	}
+	return js
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintJSON(t *testing.T) {
+	summary := querySummary{
+		count:  2,
+		sum:    3 * time.Millisecond,
+		min:    time.Millisecond,
+		max:    2 * time.Millisecond,
+		mean:   1500 * time.Microsecond,
+		median: 1500 * time.Microsecond,
+
+		throughput: 1250.5,
+	}
+	var buf bytes.Buffer
+	require.NoError(t, printJSON(&buf, summary, nil))
+	require.JSONEq(t, `{"count":2,"sum_ns":3000000,"min_ns":1000000,"max_ns":2000000,"mean_ns":1500000,"geomean_ns":0,"stddev_ns":0,"median_ns":1500000,"p95_ns":0,"p99_ns":0,"throughput_qps":1250.5}`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, printJSON(&buf, summary, []string{"throughput", "count", "median"}))
+	require.JSONEq(t, `{"count":2,"median_ns":1500000,"throughput_qps":1250.5}`, buf.String())
+}
+
+func TestValidateFormatJSON(t *testing.T) {
+	config := &CLI{Workers: 1, Iterations: 1, Input: []*os.File{os.Stdin}, Format: "json", Fields: []string{"count", "p99"}}
+	require.NoError(t, config.Validate())
+	config.Fields, config.Compact = nil, true
+	require.EqualError(t, config.Validate(), "--format json cannot be used with --compact")
+}
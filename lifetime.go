@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// lifetimeConnector is a driver.Connector whose connections each expire
+// after a random lifetime from lifetime up to lifetime+jitter, so that the
+// connections of a pool opened together are not all re-established at the
+// same time, as they would be with sql.DB.SetConnMaxLifetime.
+type lifetimeConnector struct {
+	driver.Connector
+	lifetime time.Duration
+	jitter   time.Duration
+	now      func() time.Time
+}
+
+// newLifetimeConnector returns a lifetimeConnector for the connections of c.
+func newLifetimeConnector(c driver.Connector, lifetime, jitter time.Duration) *lifetimeConnector {
+	return &lifetimeConnector{Connector: c, lifetime: lifetime, jitter: jitter, now: time.Now}
+}
+
+// Connect returns a new connection that expires after connLifetime. Expired
+// connections are closed by database/sql when they are returned to the pool.
+func (c *lifetimeConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pgxConn, ok := conn.(*stdlib.Conn)
+	if !ok {
+		return conn, nil
+	}
+	return &expiringConn{Conn: pgxConn, expires: c.now().Add(c.connLifetime()), now: c.now}, nil
+}
+
+// connLifetime returns a random lifetime for a new connection.
+func (c *lifetimeConnector) connLifetime() time.Duration {
+	if c.jitter <= 0 {
+		return c.lifetime
+	}
+	return c.lifetime + time.Duration(rand.Int63n(int64(c.jitter)))
+}
+
+// expiringConn is a pgx connection that reports itself invalid once it
+// expires, so database/sql discards it rather than reusing it.
+type expiringConn struct {
+	*stdlib.Conn
+	expires time.Time
+	now     func() time.Time
+}
+
+// IsValid implements driver.Validator.
+func (c *expiringConn) IsValid() bool {
+	return c.now().Before(c.expires)
+}
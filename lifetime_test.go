@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLifetimeJitter(t *testing.T) {
+	c := newLifetimeConnector(nil, time.Minute, 10*time.Second)
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		d := c.connLifetime()
+		require.True(t, d >= time.Minute && d < time.Minute+10*time.Second, "lifetime %v out of range", d)
+		seen[d] = true
+	}
+	require.Greater(t, len(seen), 1)
+
+	c = newLifetimeConnector(nil, time.Minute, 0)
+	require.Equal(t, time.Minute, c.connLifetime())
+}
+
+func TestExpiringConn(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &expiringConn{expires: now.Add(time.Minute), now: func() time.Time { return now }}
+	require.True(t, c.IsValid())
+	now = now.Add(time.Minute)
+	require.False(t, c.IsValid())
+}
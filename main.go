@@ -1,60 +1,380 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/csv"
 	"errors"
 	"fmt"
 	"hash/fnv"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/alecthomas/kong"
-	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/jackc/pgpassfile"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
 	"golang.org/x/sync/errgroup"
 )
 
 // CLI is the program input taken from the command line. It is annotated with
 // struct tags for github.com/alecthomas/kong to parse.
 type CLI struct {
-	Input    *os.File `arg:"" help:"Input CSV filename"`
-	DBUrl    string   `short:"u" help:"Database connect string URL (overrides individual options)"`
-	DBName   string   `short:"d" help:"Database name" env:"PGDATABASE" default:"homework"`
-	Host     string   `short:"h" help:"Database host name" env:"PGHOST" default:"localhost"`
-	Port     uint16   `short:"p" help:"Database TCP port" env:"PGPORT" default:"5432"`
-	Username string   `short:"U" help:"Database username" env:"PGUSER" default:"postgres"`
-	Password string   `short:"p" help:"Database user password" env:"PGPASSWORD"`
-	Workers  int      `short:"w" help:"Number of concurrent queries to DB" default:"1"`
+	Input    []*os.File `arg:"" optional:"" help:"Input CSV filenames, read in turn"`
+	DBUrl    string     `short:"u" help:"Database connect string URL (overrides individual options; default $DATABASE_URL or $PG_DSN)"`
+	DBName   string     `short:"d" help:"Database name" env:"PGDATABASE" default:"homework"`
+	Host     string     `short:"h" help:"Database host name, or the directory of its Unix domain socket if it starts with /" env:"PGHOST" default:"localhost"`
+	Port     uint16     `short:"p" help:"Database TCP port" env:"PGPORT" default:"5432"`
+	Username string     `short:"U" help:"Database username" env:"PGUSER" default:"postgres"`
+	Password string     `short:"W" help:"Database user password" env:"PGPASSWORD"`
+	Workers  int        `short:"w" help:"Number of concurrent queries to DB" default:"1"`
+	Buffer   int        `placeholder:"N" default:"64" help:"Number of queries and results buffered between the stages of the pipeline (0 to hand them over in lock-step)"`
 
-	db *sql.DB
+	PerWorker bool `help:"Print the number of queries and timing of each worker after the summary"`
+	TopSlow   int  `placeholder:"N" help:"Print the N slowest queries after the summary"`
+	Checksum  bool `help:"Print a checksum of the data returned by the queries, independent of their order, to compare the work of runs"`
+
+	Histogram        bool   `help:"Print a histogram of the query durations after the summary"`
+	HistogramBuckets int    `default:"10" help:"Number of buckets in the histogram"`
+	HistogramScale   string `enum:"log,linear" default:"log" help:"Histogram bucket widths: log (growing geometrically) or linear (equal)"`
+
+	ConnectionPerHost  bool          `help:"Execute the queries for each hostname on a dedicated database connection"`
+	MaxHostConnections int           `default:"100" help:"Maximum connections opened with --connection-per-host; further hostnames share them"`
+	ConnectTimeout     time.Duration `default:"10s" help:"Maximum time to wait to connect to the database before the benchmark starts (0 for no limit)"`
+	MaxOpenConns       int           `help:"Maximum open database connections (default the number of workers, or --max-host-connections with --connection-per-host)"`
+	MaxIdleConns       int           `help:"Maximum idle database connections kept open for reuse (default --max-open-conns)"`
+
+	Gzip bool `help:"Decompress the input with gzip (the default if its name ends in .gz)"`
+
+	Iterations  int           `default:"1" help:"Number of times to run the queries in the input"`
+	MaxDuration time.Duration `help:"Stop the run after this long and summarise the queries completed (0 for no limit)"`
+	ReopenInput bool          `help:"Reopen the input file by name for each iteration instead of seeking, e.g. for named pipes"`
+
+	Probe        bool `xor:"mode" help:"Check the database connection and schema and run a sample query instead of the benchmark"`
+	ValidateOnly bool `xor:"mode" help:"Run the preflight checks on the database and input and exit with a status encoding the failed checks instead of running the benchmark"`
+	DryRun       bool `xor:"mode" help:"Parse the whole input and report the number of valid queries and the first error without connecting to the database"`
+
+	PasswordFile string `type:"path" placeholder:"FILE" help:"Read the database user password from this file"`
+	PgpassFile   string `type:"path" placeholder:"FILE" env:"PGPASSFILE" help:"Password file in .pgpass format (default ~/.pgpass)"`
+
+	Retries             int           `help:"Retry a query failing with a transient error, such as a dropped connection, up to this many times"`
+	RetryBackoff        time.Duration `default:"100ms" help:"Wait before the first retry of a query, doubling for each further retry"`
+	RetryIdempotentOnly bool          `help:"Only retry queries whose SQL is known to be read-only, so a retry cannot repeat a write"`
+
+	ContinueOnError  bool          `help:"Count failed queries and continue instead of stopping the run at the first error"`
+	QueryTimeout     time.Duration `help:"Abandon a query taking longer than this and count it as timed out (0 to disable)"`
+	StallTimeout     time.Duration `help:"Skip remaining queries for a host once one takes longer than this (0 to disable)"`
+	PlanWarmup       bool          `help:"Execute each distinct query shape once untimed to warm the plan cache"`
+	BatchSize        int           `default:"1" placeholder:"N" help:"Execute up to N adjacent queries for the same hostname in a single statement, dividing its duration between them"`
+	PercentileMethod string        `enum:"nearest,linear" default:"nearest" help:"Percentile calculation method: nearest (nearest-rank) or linear (interpolated)"`
+	ApproxQuantiles  bool          `help:"Estimate the median and percentiles in constant memory instead of retaining all results, for very large inputs"`
+	ResultsLimit     int           `help:"Maximum results retained for median and percentiles; beyond this a random sample is kept (0 for no limit)"`
+	QueryComment     string        `help:"Comment to add to the benchmark SQL, e.g. to identify it in pg_stat_statements"`
+	Query            string        `xor:"sql" placeholder:"NAME|SQL" help:"Query to benchmark: minmax (the default), avg, count, percentile or bucket, or SQL using $1, $2 and $3 for the hostname, start and end time"`
+	Bucket           time.Duration `default:"1m" help:"Width of the time buckets of --query bucket"`
+	SQLTemplate      string        `xor:"sql" name:"sql-template" placeholder:"TEMPLATE" help:"Go template of the SQL to benchmark, using {{.Hostname}}, {{.Start}} and {{.End}} for the query parameters"`
+	TimestampCast    string        `enum:"none,timestamp,timestamptz" default:"none" help:"Cast the start and end time parameters to this type in the SQL, e.g. timestamp to match a column without time zone"`
+	Table            string        `placeholder:"NAME" default:"cpu_usage" help:"Table queried, optionally qualified by its schema, e.g. metrics.cpu_usage"`
+	ValueColumn      string        `placeholder:"NAME" default:"usage" help:"Column of the table with the values aggregated by the query"`
+	HostColumn       string        `placeholder:"NAME" default:"host" help:"Column of the table with the hostnames"`
+	TsColumn         string        `placeholder:"NAME" default:"ts" help:"Column of the table with the timestamps"`
+
+	MaxTotalQueries int     `help:"Stop the run once this many queries have been executed (0 for no limit)"`
+	Rate            float64 `placeholder:"QPS" help:"Dispatch queries to the workers at no more than this many per second (0 for no limit)"`
+	Limit           int     `help:"Stop reading the input after this many queries, across all iterations (0 for no limit)"`
+	Warmup          int     `placeholder:"N" help:"Execute the first N queries to warm the database but exclude them from the summary"`
+	LowercaseHosts  bool    `help:"Lowercase the hostnames in the input before querying"`
+
+	ColHostname string `placeholder:"NAME" default:"hostname" help:"Name of the hostname column in the input"`
+	ColStart    string `placeholder:"NAME" default:"start_time" help:"Name of the start time column in the input"`
+	ColEnd      string `placeholder:"NAME" default:"end_time" help:"Name of the end time column in the input"`
+	SkipBadRows bool   `help:"Log and skip invalid input rows instead of stopping at the first, exiting with an error after the summary"`
+	NoHeader    bool   `help:"The input has no header; its columns are the hostname, start and end time in that order"`
+	Delimiter   string `default:"," help:"Field delimiter of the input, a single character such as ; or \\t for tab"`
+	TimeFormat  string `placeholder:"LAYOUT" default:"2006-01-02 15:04:05" help:"Go time layout of the start and end times in the input; times without a zone are in --input-tz"`
+	InputTZ     string `name:"input-tz" placeholder:"ZONE" default:"UTC" help:"Time zone of the start and end times in the input, e.g. Australia/Sydney; they are queried in UTC"`
+
+	HostnameMap       string `type:"path" placeholder:"FILE" help:"CSV or JSON file mapping input hostnames to database hostnames"`
+	HostnameMapStrict bool   `help:"Fail on input hostnames not in the hostname map instead of passing them through"`
+
+	MaxCPUUsage float64 `name:"max-cpu-usage" placeholder:"USAGE" help:"Flag queries returning a max CPU usage above this implausible value (0 to disable)"`
+
+	FailOnNoRowsPercentage float64 `placeholder:"PERCENT" help:"Fail the run if more than this percentage of queries return no data (0 to disable)"`
+
+	Dedupe            bool    `help:"Skip queries with the same hostname, start and end time as an earlier query"`
+	CheckOverlap      bool    `help:"Warn about queries whose window overlaps an earlier window for the same hostname"`
+	Sort              bool    `help:"Read all queries and execute them sorted by hostname and start time, holding the whole input in memory"`
+	ResultDedupWindow float64 `placeholder:"FRACTION" help:"Collapse queries for a host whose window overlaps an earlier query's window by more than this fraction (0 to disable)"`
+
+	SSLMode     string            `name:"sslmode" env:"PGSSLMODE" placeholder:"MODE" help:"TLS mode of the database connection: disable, allow, prefer, require, verify-ca or verify-full (default disable for localhost, otherwise prefer)"`
+	DBParams    map[string]string `name:"db-param" mapsep:"none" placeholder:"KEY=VALUE" help:"Extra database connection parameter (repeatable)"`
+	DNSCacheTTL time.Duration     `name:"dns-cache" help:"Cache DNS lookups of the database host for this long (0 to disable)"`
+
+	MaxConnLifetime       time.Duration `name:"max-connection-lifetime" help:"Close database connections after this long (0 to keep them open)"`
+	MaxConnLifetimeJitter time.Duration `name:"max-connection-lifetime-jitter" help:"Add a random duration up to this to the lifetime of each connection to spread reconnections"`
+
+	Format      string   `enum:"text,json" default:"text" help:"Format of the summary: text or json (durations in integer nanoseconds)"`
+	Color       string   `enum:"auto,always,never" default:"auto" help:"Colour the summary output: auto (if stdout is a terminal), always or never"`
+	Unit        string   `enum:"auto,ms,us,ns" default:"auto" help:"Unit of the durations in the text summary: auto, ms, us or ns"`
+	Precision   int      `default:"3" help:"Decimal places of the durations in the text summary with --unit ms, us or ns"`
+	Compact     bool     `xor:"format" help:"Print the summary as a single line"`
+	Fields      []string `xor:"format" placeholder:"FIELD,..." help:"Print only these summary fields (count, sum, min, max, mean, geomean, stddev, median, p95, p99, elapsed, qps, throughput)"`
+	SummaryLine bool     `help:"Print a final RESULT line of key=value pairs of the summary for scripts, after any other output (on stderr with --format json)"`
+
+	OutputCSV            string        `name:"output-csv" type:"path" placeholder:"FILE" help:"Write the result of each query to this CSV file"`
+	ResultsFlushInterval time.Duration `help:"Flush the results CSV file at this interval (0 to flush only at the end)"`
+	DumpDurations        string        `type:"path" placeholder:"FILE" help:"Write the duration of each query in microseconds to this file, one per line"`
+	Flamegraph           string        `type:"path" placeholder:"FILE" help:"Write the time spent in each phase of the queries for each host to this file in collapsed stack format"`
+	CPUProfile           string        `name:"cpuprofile" type:"path" placeholder:"FILE" help:"Write a CPU profile of tsbench itself to this file"`
+	MemProfile           string        `name:"memprofile" type:"path" placeholder:"FILE" help:"Write a heap profile of tsbench itself to this file at the end of the run"`
+	Explain              string        `type:"path" placeholder:"FILE" help:"Write the EXPLAIN (ANALYZE, BUFFERS) plan of each distinct query to this file, executed untimed after the query"`
+	ExplainSample        int           `placeholder:"N" help:"Explain only the first N distinct queries (0 for all)"`
+
+	SummaryInterval time.Duration `help:"Print an interim summary to stderr at this interval during the run (0 to disable)"`
+	StatsWindow     time.Duration `help:"Report interim summaries over this most recent window instead of the whole run so far"`
+
+	Progress         bool          `help:"Show the number of queries executed on stderr during the run, if stdout is a terminal"`
+	ProgressInterval time.Duration `default:"1s" help:"Interval at which --progress is updated"`
+	ForceProgress    bool          `help:"Show progress as with --progress even if stdout is not a terminal"`
+	Verbose          bool          `short:"v" help:"Log each query and its duration to stderr as it completes"`
+
+	MetricsFile   string `help:"Write a latency histogram and quantiles of the query durations to this file"`
+	MetricsFormat string `enum:"openmetrics,prometheus" default:"openmetrics" help:"Format of the metrics file: openmetrics (with exemplars) or prometheus (text exposition format, e.g. for a Pushgateway)"`
+	HistoryFile   string `help:"Append the summary of the run as a line of JSON to this file"`
+	Tag           string `help:"Tag identifying the run in the history file"`
+
+	Baseline  string  `type:"path" placeholder:"FILE" help:"Compare the summary with that of an earlier run in this JSON summary or history file"`
+	Threshold float64 `placeholder:"PERCENT" default:"10" help:"Fail if the mean, median or p95 is this percentage slower, or the throughput this percentage lower, than the baseline"`
+
+	SummaryIncludeConfig bool `help:"Include the configuration of the run, with the password redacted, in the JSON summary"`
+
+	db          *sql.DB
+	resultsCSV  io.Writer
+	durations   io.Writer
+	interim     io.Writer
+	progress    io.Writer
+	verbose     io.Writer
+	skippedRows io.Writer
+	overlaps    io.Writer
+	flamegraph  io.Writer
+	hostnameMap map[string]string
+	inputTZ     *time.Location
 }
 
 func (c *CLI) Validate() error {
+	if len(c.Input) == 0 && !c.Probe {
+		return errors.New("expected \"<input>\"")
+	}
 	if c.Workers <= 0 {
 		return fmt.Errorf("invalid number of workers. must be a positive integer: %d", c.Workers)
 	}
+	if c.MaxHostConnections <= 0 && c.ConnectionPerHost {
+		return fmt.Errorf("invalid maximum host connections. must be a positive integer: %d", c.MaxHostConnections)
+	}
+	if c.ConnectTimeout < 0 {
+		return fmt.Errorf("invalid connect timeout. must not be negative: %v", c.ConnectTimeout)
+	}
+	if c.MaxOpenConns < 0 || c.MaxIdleConns < 0 {
+		return errors.New("invalid maximum connections. must not be negative")
+	}
+	if c.ConnectionPerHost && c.MaxOpenConns > 0 && c.MaxOpenConns < c.MaxHostConnections {
+		return errors.New("--max-open-conns must be at least --max-host-connections with --connection-per-host")
+	}
+	if c.Retries < 0 {
+		return fmt.Errorf("invalid number of retries. must not be negative: %d", c.Retries)
+	}
+	if c.Iterations <= 0 {
+		return fmt.Errorf("invalid number of iterations. must be a positive integer: %d", c.Iterations)
+	}
+	if strings.Contains(c.QueryComment, "*/") || strings.Contains(c.QueryComment, "/*") {
+		return fmt.Errorf("invalid query comment. must not contain /* or */: %s", c.QueryComment)
+	}
+	if _, err := querySQL(c); err != nil {
+		return err
+	}
+	if c.Dedupe && c.Iterations > 1 {
+		return errors.New("--dedupe cannot be used with --iterations, as it would skip every query after the first iteration")
+	}
+	if c.ResultDedupWindow < 0 || c.ResultDedupWindow >= 1 {
+		return fmt.Errorf("invalid dedup window. must be a fraction from 0 up to 1: %v", c.ResultDedupWindow)
+	}
+	if c.MaxCPUUsage < 0 {
+		return fmt.Errorf("invalid max CPU usage. must not be negative: %v", c.MaxCPUUsage)
+	}
+	if c.FailOnNoRowsPercentage < 0 || c.FailOnNoRowsPercentage > 100 {
+		return fmt.Errorf("invalid no rows percentage. must be from 0 to 100: %v", c.FailOnNoRowsPercentage)
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("invalid rate. must not be negative: %v", c.Rate)
+	}
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("invalid maximum duration. must not be negative: %v", c.MaxDuration)
+	}
+	if c.MaxTotalQueries < 0 {
+		return fmt.Errorf("invalid maximum total queries. must not be negative: %d", c.MaxTotalQueries)
+	}
+	if c.Buffer < 0 {
+		return fmt.Errorf("invalid buffer. must not be negative: %d", c.Buffer)
+	}
+	if c.Format == "json" && c.Compact {
+		return errors.New("--format json cannot be used with --compact")
+	}
+	if err := validateFields(c.Fields); err != nil {
+		return err
+	}
+	if c.Precision < 0 || c.Precision > 9 {
+		return fmt.Errorf("invalid precision. must be between 0 and 9: %d", c.Precision)
+	}
+	if c.MaxConnLifetimeJitter < 0 || c.MaxConnLifetime < 0 {
+		return errors.New("invalid max connection lifetime. must not be negative")
+	}
+	if c.MaxConnLifetimeJitter > 0 && c.MaxConnLifetime == 0 {
+		return errors.New("--max-connection-lifetime-jitter requires --max-connection-lifetime")
+	}
+	if c.ProgressInterval <= 0 && (c.Progress || c.ForceProgress) {
+		return fmt.Errorf("invalid progress interval. must be positive: %v", c.ProgressInterval)
+	}
+	if c.StatsWindow > 0 && c.SummaryInterval == 0 {
+		return errors.New("--stats-window requires --summary-interval")
+	}
+	if c.ApproxQuantiles && c.ResultsLimit > 0 {
+		return errors.New("--approx-quantiles cannot be used with --results-limit")
+	}
+	if c.HistogramBuckets <= 0 && c.Histogram {
+		return fmt.Errorf("invalid number of histogram buckets. must be a positive integer: %d", c.HistogramBuckets)
+	}
+	if c.ResultsLimit < 0 {
+		return fmt.Errorf("invalid results limit. must not be negative: %d", c.ResultsLimit)
+	}
+	if c.Limit < 0 {
+		return fmt.Errorf("invalid limit. must not be negative: %d", c.Limit)
+	}
+	if _, err := csvDelimiter(c.Delimiter); err != nil {
+		return err
+	}
+	if c.Threshold < 0 {
+		return fmt.Errorf("invalid threshold. must not be negative: %v", c.Threshold)
+	}
+	if c.Bucket < 0 || c.Bucket > 0 && c.Bucket < time.Microsecond {
+		return fmt.Errorf("invalid bucket width. must be at least 1µs: %v", c.Bucket)
+	}
+	if c.TopSlow < 0 {
+		return fmt.Errorf("invalid number of slowest queries. must not be negative: %d", c.TopSlow)
+	}
+	if c.Warmup < 0 {
+		return fmt.Errorf("invalid number of warmup queries. must not be negative: %d", c.Warmup)
+	}
+	if c.BatchSize < 0 {
+		return fmt.Errorf("invalid batch size. must not be negative: %d", c.BatchSize)
+	}
+	if c.BatchSize > 1 && (c.ConnectionPerHost || c.Retries > 0 || c.StallTimeout > 0 || c.PlanWarmup || c.Explain != "") {
+		return errors.New("--batch-size cannot be used with --connection-per-host, --retries, --stall-timeout, --plan-warmup or --explain")
+	}
+	if c.Explain != "" && c.ConnectionPerHost {
+		// The EXPLAIN runs on the shared pool, all of whose connections
+		// may be held by the hosts.
+		return errors.New("--explain cannot be used with --connection-per-host")
+	}
+	if c.ExplainSample < 0 {
+		return fmt.Errorf("invalid explain sample. must not be negative: %d", c.ExplainSample)
+	}
 	return nil
 }
 
+// timeLayout is the default format of the start and end times in the input
+// CSV file, and the format times are output in.
+const timeLayout = "2006-01-02 15:04:05"
+
 // query is a single parsed query from the input CSV file.
 type query struct {
 	hostname   string
 	start, end time.Time
+
+	// expected is the maximum duration the query is expected to take. It
+	// is zero if the input has no expected_duration column or the value
+	// for the query was empty.
+	expected time.Duration
+
+	// warmup is true if the query is one of the first queries of the run,
+	// executed to warm the database and excluded from the summary.
+	warmup bool
 }
 
 // queryResult is the result of executing a query against the database.
 type queryResult struct {
+	// query is the query that was executed to produce this result.
+	query query
+
 	// minCPU and maxCPU is the minimum and maximum CPU time for a host
-	// within the start and end time of a query.
+	// within the start and end time of a query. They are only set if hasCPU
+	// is true, i.e. the query returned them, which a custom query does not.
 	minCPU, maxCPU float64
+	hasCPU         bool
 
 	// queryDuration is the amount of time it took to execute the query
 	// against the database and retrieve the result.
 	queryDuration time.Duration
+
+	// firstRowDuration is the amount of time from executing the query
+	// until the first row of the result was available. For multi-row
+	// results, queryDuration minus this is the time to transfer the rest.
+	firstRowDuration time.Duration
+
+	// skipped is true if the query was not executed, or was abandoned,
+	// because its host stalled. Skipped results have no timing.
+	skipped bool
+
+	// timedOut is true if the query was abandoned because it took longer
+	// than the query timeout. Timed out results have no timing.
+	timedOut bool
+
+	// err is the error executing the query, if it failed and the run
+	// continues on errors. Failed results have no timing.
+	err error
+
+	// planWarmup is true if the query was executed only to warm the
+	// database plan cache and is not part of the benchmark.
+	planWarmup bool
+
+	// worker is the number of the worker that executed the query.
+	worker int
+
+	// retries is the number of times the query was retried after a
+	// transient error before it succeeded.
+	retries int
+
+	// executeDuration is the time until the database accepted the query
+	// and started returning its result. It includes waiting for a pooled
+	// connection and any re-preparing of the statement on it.
+	executeDuration time.Duration
+
+	// buckets is the number of time buckets returned by a bucket query.
+	buckets int
+
+	// noData is true if the query returned no rows, or no CPU usage for
+	// the default query, because there is no data for its window.
+	noData bool
+}
+
+// executed returns true if qr is the timed result of a query of the
+// benchmark, i.e. not a skipped, timed out, failed or warmup result.
+func (qr queryResult) executed() bool {
+	return !qr.skipped && !qr.timedOut && qr.err == nil && !qr.planWarmup && !qr.query.warmup
 }
 
 type querySummary struct {
@@ -64,70 +384,899 @@ type querySummary struct {
 	max    time.Duration
 	mean   time.Duration
 	median time.Duration
+	p95    time.Duration
+	p99    time.Duration
+
+	// stddev is the population standard deviation of the query durations.
+	stddev time.Duration
+
+	// geomean is the geometric mean of the query durations, which is less
+	// affected by outliers than the mean. Zero durations are excluded as
+	// they have no logarithm.
+	geomean time.Duration
+
+	// latency is a histogram of the query durations.
+	latency latencyHistogram
+
+	// retained is the number of results the median and percentiles were
+	// calculated from. It is less than count if results were sampled.
+	retained int
+
+	// results are the retained results, sorted by duration.
+	results []queryResult
+
+	// approx is true if the median and percentiles were estimated by
+	// streaming quantile estimators rather than from retained results.
+	approx bool
+
+	// elapsed is the wall clock time taken for the whole run.
+	elapsed time.Duration
+
+	// partial is true if the run was cancelled before all the results
+	// were summarised.
+	partial bool
+
+	// timeLimited is true if the run was stopped by reaching its maximum
+	// duration.
+	timeLimited bool
+
+	// capped is true if the run was stopped by reaching the maximum total
+	// number of queries.
+	capped bool
+
+	// collapsed is the number of input queries not executed because they
+	// were near-duplicates of an earlier query.
+	collapsed int
+
+	// duplicates is the number of input queries not executed because they
+	// were identical to an earlier query.
+	duplicates int
+
+	// distinctHosts is the number of distinct hostnames of the queries,
+	// whether or not they succeeded. It bounds the parallelism of
+	// --connection-per-host, which executes queries for a host in turn.
+	distinctHosts int
+
+	// checksum is the checksum of the data returned by the queries, if
+	// computed. See resultChecksum.
+	checksum string
+
+	// requestedRate is the rate in queries per second the queries were
+	// limited to, or 0 if not limited.
+	requestedRate float64
+
+	// overlaps is the number of input queries whose window overlaps an
+	// earlier window for the same hostname, if checked.
+	overlaps int
+
+	// badRows is the number of invalid input rows skipped.
+	badRows int
+
+	// sloChecked is true if any query had an expected duration, and
+	// sloBreaches holds the results of queries that took longer than
+	// their expected duration.
+	sloChecked  bool
+	sloBreaches []queryResult
+
+	// skipped is the number of queries skipped because their host stalled,
+	// and skippedHosts are the hostnames of those stalled hosts.
+	skipped      int
+	skippedHosts []string
+
+	// slowest holds the slowest results, slowest first, if the number of
+	// them to report is set by config.TopSlow.
+	slowest []queryResult
+
+	// overMaxCPU holds the results with a maximum CPU usage above the
+	// configured maximum plausible value, indicating bad data.
+	overMaxCPU []queryResult
+
+	// overallMinCPU and overallMaxCPU are the minimum and maximum CPU
+	// usage returned by any query. They are only set if cpuObserved is
+	// true, i.e. a query returned CPU usage.
+	overallMinCPU, overallMaxCPU float64
+	cpuObserved                  bool
+
+	// firstRowMean is the mean time until the first row of a query result
+	// was available.
+	firstRowMean time.Duration
+
+	// planWarmups is the number of untimed queries executed to warm the
+	// database plan cache.
+	planWarmups int
+
+	// warmups is the number of queries executed at the start of the run
+	// to warm the database, which are excluded from the summary.
+	warmups int
+
+	// backpressure is how often workers blocked sending results to the
+	// summariser.
+	backpressure backpressure
+
+	// noData is the number of queries that returned no data.
+	noData int
+
+	// lastResult is when the last result was received, and throughput is
+	// the number of queries per second from when the first query was
+	// dispatched until then. Unlike qps, it excludes the time to connect
+	// and start the run, and unlike the sum of the query durations, it
+	// reflects the concurrency of the workers.
+	lastResult time.Time
+	throughput float64
+
+	// timedOut is the number of queries abandoned after the query timeout.
+	timedOut int
+
+	// errors is the number of queries that failed, and firstError the
+	// error of the first of them, if the run continued on errors.
+	errors     int
+	firstError error
+
+	// retries is the total number of retries of queries after transient
+	// errors.
+	retries int
+
+	// config is the configuration of the run, as from configDump, if it
+	// is to be included in the JSON summary.
+	config map[string]interface{}
+
+	// workers is the breakdown of the results by the worker that executed
+	// them, ordered by worker, if config.PerWorker is set.
+	workers []workerSummary
+}
+
+// workerSummary is the summary of the results executed by one worker.
+type workerSummary struct {
+	id    int
+	count int
+	sum   time.Duration
+	min   time.Duration
+	max   time.Duration
+}
+
+// mean returns the mean duration of the queries executed by the worker.
+func (w workerSummary) mean() time.Duration {
+	if w.count == 0 {
+		return 0
+	}
+	return w.sum / time.Duration(w.count)
+}
+
+// noDataPercentage returns the percentage of queries that returned no data.
+func (s querySummary) noDataPercentage() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return float64(s.noData) * 100 / float64(s.count)
+}
+
+// checkNoData returns an error if more than maxPercentage percent of the
+// queries in summary returned no data. A maxPercentage of zero disables the
+// check.
+func checkNoData(summary querySummary, maxPercentage float64) error {
+	if maxPercentage <= 0 || summary.noDataPercentage() <= maxPercentage {
+		return nil
+	}
+	return fmt.Errorf("%.1f%% of queries returned no data, more than %v%%", summary.noDataPercentage(), maxPercentage)
+}
+
+// backpressure is how often, and for how long in total, workers were blocked
+// sending results because the next stage of the pipeline was not ready.
+type backpressure struct {
+	blocked     int
+	blockedTime time.Duration
+}
+
+// add adds the blocked time d to b, if d is non-zero.
+func (b *backpressure) add(d time.Duration) {
+	if d > 0 {
+		b.blocked++
+		b.blockedTime += d
+	}
+}
+
+// qps returns the number of queries per second over the elapsed time of the
+// run.
+func (s querySummary) qps() float64 {
+	if s.elapsed <= 0 {
+		return 0
+	}
+	return float64(s.count) / s.elapsed.Seconds()
 }
 
 func main() {
 	cli := &CLI{}
-	kong.Parse(cli)
-	defer cli.Input.Close()
+	kong.Parse(cli, kong.TypeMapper(reflect.TypeOf(&os.File{}), inputMapper))
+	for _, f := range cli.Input {
+		defer f.Close()
+	}
+	cli.DBUrl = resolveDBUrl(cli.DBUrl, os.Getenv)
+
+	if cli.HostnameMap != "" {
+		m, err := loadHostnameMap(cli.HostnameMap)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		cli.hostnameMap = m
+	}
+	loc, err := time.LoadLocation(cli.InputTZ)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid input time zone: %v\n", err)
+		os.Exit(1)
+	}
+	cli.inputTZ = loc
+	var baseline jsonSummary
+	if cli.Baseline != "" {
+		if baseline, err = loadBaseline(cli.Baseline); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if cli.DryRun {
+		inputs, err := inputReaders(cli)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		if err := dryRun(os.Stdout, cli, inputs); err != nil {
+			os.Exit(exitInput)
+		}
+		os.Exit(0)
+	}
 
 	db, err := dbconnect(cli)
+	if err == nil && isTerminal(os.Stdin) {
+		db, err = reconnectWithPassword(cli, db, func() (string, error) {
+			return promptPassword(os.Stdin, os.Stderr, "Password for "+cli.Username+": ")
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+	cli.db = db
+
+	if cli.Probe {
+		if err := runProbe(cli); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if cli.ValidateOnly {
+		inputs, err := inputReaders(cli)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(checkInput)
+		}
+		os.Exit(validate(context.Background(), os.Stdout, cli, sqlProbeDB{db: cli.db, names: newSchemaNames(cli)}, inputs))
+	}
+
+	stopProfiles, err := startProfiles(cli)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	cli.db = db
+	ctx, stop := interruptContext()
+	defer stop()
+	start := time.Now()
+	summary, err := run(ctx, cli)
+	summary.elapsed = time.Since(start)
+	if perr := stopProfiles(); perr != nil {
+		fmt.Fprintln(os.Stderr, perr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+
+	if cli.SummaryIncludeConfig {
+		summary.config = configDump(cli)
+	}
+	durations := newDurationFormat(cli.Unit, cli.Precision)
+	switch {
+	case cli.Format == "json":
+		if err := printJSON(os.Stdout, summary, cli.Fields); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case cli.Compact:
+		printCompact(os.Stdout, summary, durations)
+	case len(cli.Fields) > 0:
+		printFields(os.Stdout, summary, cli.Fields, durations)
+	default:
+		printSummary(os.Stdout, summary, durations, newPalette(cli.Color, os.Stdout))
+		if cli.MaxCPUUsage > 0 {
+			printOverMaxCPU(os.Stdout, summary, cli.MaxCPUUsage, newPalette(cli.Color, os.Stdout))
+		}
+		if cli.PerWorker {
+			printWorkers(os.Stdout, summary, durations)
+		}
+		if cli.TopSlow > 0 {
+			printSlowest(os.Stdout, summary, durations)
+		}
+		if cli.Histogram {
+			if summary.approx {
+				fmt.Fprintln(os.Stdout, "Histogram: not available with --approx-quantiles")
+			} else {
+				printHistogram(os.Stdout, durationHistogram(summary.results, cli.HistogramBuckets, cli.HistogramScale), durations)
+			}
+		}
+	}
+
+	// The JSON summary is kept to a single line on stdout, so that it can
+	// be saved as a baseline, and the rest of the output goes to stderr.
+	w := os.Stdout
+	if cli.Format == "json" {
+		w = os.Stderr
+	}
+	var regression error
+	if cli.Baseline != "" {
+		changes := compareBaseline(baseline, newJSONSummary(summary), cli.Threshold, durations)
+		regression = printBaselineChanges(w, changes, newPalette(cli.Color, w))
+	}
+	if cli.SummaryLine {
+		printSummaryLine(w, summary)
+	}
+
+	if err := checkNoData(summary, cli.FailOnNoRowsPercentage); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := skippedRowsError(summary.badRows); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitInput)
+	}
+
+	if cli.MetricsFile != "" {
+		if err := writeMetricsFile(cli.MetricsFile, cli.MetricsFormat, summary); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if cli.HistoryFile != "" {
+		if err := appendHistory(cli.HistoryFile, cli.Tag, start, summary); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if regression != nil {
+		fmt.Fprintln(os.Stderr, regression)
+		os.Exit(exitRegression)
+	}
+
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+	os.Exit(0)
+}
+
+// interruptContext returns a context that is cancelled when the process
+// receives SIGINT or SIGTERM, and a function to stop waiting for them. Only
+// the first signal is caught, so a second one terminates the process if
+// it does not finish promptly.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+// dbconnect returns a database connection pool for config. Errors are
+// returned as a connectError.
+func dbconnect(config *CLI) (*sql.DB, error) {
+	url, err := dsn(config)
+	if err != nil {
+		return nil, &connectError{err}
+	}
+	if config.DNSCacheTTL != 0 {
+		connConfig, err := pgx.ParseConfig(url)
+		if err != nil {
+			return nil, &connectError{err}
+		}
+		cache := newDNSCache(config.DNSCacheTTL, lookupFunc(connConfig.LookupFunc))
+		connConfig.LookupFunc = cache.lookupHost
+		url = stdlib.RegisterConnConfig(connConfig)
+	}
+
+	connector, err := stdlib.GetDefaultDriver().(driver.DriverContext).OpenConnector(url)
+	if err != nil {
+		return nil, &connectError{err}
+	}
+	if config.MaxConnLifetime > 0 && config.MaxConnLifetimeJitter > 0 {
+		connector = newLifetimeConnector(connector, config.MaxConnLifetime, config.MaxConnLifetimeJitter)
+	}
+	db := sql.OpenDB(connector)
+	maxOpen := maxOpenConns(config)
+	db.SetMaxOpenConns(maxOpen)
+	idle := config.MaxIdleConns
+	if idle == 0 {
+		idle = maxOpen
+	}
+	db.SetMaxIdleConns(idle)
+	if config.MaxConnLifetime > 0 && config.MaxConnLifetimeJitter == 0 {
+		db.SetConnMaxLifetime(config.MaxConnLifetime)
+	}
+	return db, nil
+}
+
+// pingDB checks that db can be connected to, waiting at most timeout if it
+// is not zero. As sql.DB connects lazily, this reports a bad connection
+// string or unreachable host before the benchmark starts rather than on its
+// first query.
+func pingDB(db *sql.DB, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if err := db.PingContext(ctx); err != nil {
+		return &connectError{fmt.Errorf("cannot connect to database: %w", err)}
+	}
+	return nil
+}
+
+// maxOpenConns returns the maximum number of open connections of the
+// database pool for config. Unless set by config.MaxOpenConns, it is the
+// number of workers, as each executes one query at a time, or the maximum
+// number of host connections with config.ConnectionPerHost, as those are
+// held for the whole run. The idle connections default to the same number
+// so that connections are not closed and reopened between queries, as they
+// would be with the database/sql default of two idle connections.
+func maxOpenConns(config *CLI) int {
+	switch {
+	case config.MaxOpenConns > 0:
+		return config.MaxOpenConns
+	case config.ConnectionPerHost:
+		return config.MaxHostConnections
+	default:
+		return config.Workers
+	}
+}
+
+// dsn returns the database connection URL for config. If config has a DBUrl,
+// that is used, otherwise the URL is assembled from the individual options.
+// The SSLMode, if set, and any extra DBParams are added to the query string
+// of the URL, or appended to a DBUrl that is a keyword/value connection
+// string. If SSLMode is not set, TLS is disabled for an assembled URL to
+// localhost or a Unix domain socket.
+func dsn(config *CLI) (string, error) {
+	dbURL := config.DBUrl
+	if dbURL == "" {
+		password, err := resolvePassword(config)
+		if err != nil {
+			return "", err
+		}
+		user := url.User(config.Username)
+		if password != "" {
+			user = url.UserPassword(config.Username, password)
+		}
+		if isSocketDir(config.Host) {
+			dbURL = socketURL(config, user)
+		} else {
+			format := "postgres://%s@%s:%d/%s"
+			dbURL = fmt.Sprintf(format, user, config.Host, config.Port, config.DBName)
+			if config.Host == "localhost" && config.SSLMode == "" {
+				dbURL += "?sslmode=disable"
+			}
+		}
+	}
+	if len(config.DBParams) == 0 && config.SSLMode == "" {
+		return dbURL, nil
+	}
+	if !isDatabaseURL(dbURL) {
+		if config.SSLMode != "" {
+			dbURL += " sslmode=" + dsnValue(config.SSLMode)
+		}
+		keys := make([]string, 0, len(config.DBParams))
+		for k := range config.DBParams {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			dbURL += " " + k + "=" + dsnValue(config.DBParams[k])
+		}
+		return dbURL, nil
+	}
+
+	u, err := url.Parse(dbURL)
+	if err != nil {
+		// Do not include the URL in the error as it may contain a password.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return "", fmt.Errorf("invalid database URL: %w", err)
+	}
+	params := u.Query()
+	if config.SSLMode != "" {
+		params.Set("sslmode", config.SSLMode)
+	}
+	for k, v := range config.DBParams {
+		params.Set(k, v)
+	}
+	u.RawQuery = params.Encode()
+	return u.String(), nil
+}
+
+// dsnValue returns v quoted as a value of a keyword/value connection string
+// if it is empty or contains spaces, quotes or backslashes.
+func dsnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n'\\") {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// dsnEnvVars are the environment variables that may hold the database URL,
+// in order of precedence.
+var dsnEnvVars = []string{"DATABASE_URL", "PG_DSN"}
+
+// resolveDBUrl returns the database URL to connect with: dbURL, as given by
+// --db-url, if set, otherwise the first of the dsnEnvVars set in the
+// environment looked up with getenv. If neither is set, it returns an empty
+// URL and the URL is assembled from the individual options.
+func resolveDBUrl(dbURL string, getenv func(string) string) string {
+	if dbURL != "" {
+		return dbURL
+	}
+	for _, name := range dsnEnvVars {
+		if v := getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// isSocketDir returns true if host is the directory of a Unix domain socket
+// rather than a host name, as with libpq.
+func isSocketDir(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
+
+// socketURL returns the database connection URL for config connecting as
+// user over the Unix domain socket in the directory config.Host. The
+// directory is given as the host parameter as it cannot be the host of a
+// URL. The port is only given if it is not the default, as it names the
+// socket file rather than a TCP port.
+func socketURL(config *CLI, user *url.Userinfo) string {
+	params := url.Values{"host": {config.Host}}
+	if config.Port != 0 && config.Port != 5432 {
+		params.Set("port", strconv.Itoa(int(config.Port)))
+	}
+	if config.SSLMode == "" {
+		params.Set("sslmode", "disable")
+	}
+	u := url.URL{Scheme: "postgres", User: user, Path: "/" + config.DBName, RawQuery: params.Encode()}
+	return u.String()
+}
+
+// resolvePassword returns the database password for config. It is the
+// Password if set, otherwise the contents of the PasswordFile without a
+// trailing newline, otherwise the password for the connection found in the
+// pgpass file. If none of these give a password, an empty password is
+// returned.
+func resolvePassword(config *CLI) (string, error) {
+	if config.Password != "" {
+		return config.Password, nil
+	}
+	if config.PasswordFile != "" {
+		b, err := ioutil.ReadFile(config.PasswordFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return pgpassPassword(config)
+}
+
+// pgpassPassword returns the password for the connection of config found in
+// the PgpassFile, or ~/.pgpass if not set. It returns an empty password if
+// there is none, or if ~/.pgpass does not exist.
+func pgpassPassword(config *CLI) (string, error) {
+	filename := config.PgpassFile
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		filename = filepath.Join(home, ".pgpass")
+	}
+	passfile, err := pgpassfile.ReadPassfile(filename)
+	if err != nil {
+		if os.IsNotExist(err) && config.PgpassFile == "" {
+			return "", nil
+		}
+		return "", err
+	}
+	host := config.Host
+	if isSocketDir(host) {
+		// Entries for Unix domain socket connections have the host
+		// localhost, as with libpq.
+		host = "localhost"
+	}
+	port := strconv.Itoa(int(config.Port))
+	return passfile.FindPassword(host, port, config.DBName, config.Username), nil
+}
+
+// run executes the tsbench data pipeline against the database and returns
+// the result of the benchmark.
+func run(ctx context.Context, config *CLI) (summary querySummary, err error) {
+	if err := pingDB(config.db, config.ConnectTimeout); err != nil {
+		return querySummary{}, err
+	}
+
+	var exec connExecutor
+	if config.ConnectionPerHost {
+		exec = newHostConnExecutor(config.MaxHostConnections, func(ctx context.Context) (connExecutor, error) {
+			return newConnStmtExecutor(ctx, config.db, config)
+		})
+	} else if exec, err = newStmtExecutor(config.db, config); err != nil {
+		return querySummary{}, &queryError{err}
+	}
+	defer exec.close()
+	if config.Retries > 0 {
+		bsql, err := querySQL(config)
+		if err != nil {
+			return querySummary{}, err
+		}
+		exec = newRetryExecutor(exec, config.Retries, config.RetryBackoff, config.RetryIdempotentOnly, bsql.text)
+	}
+	if config.Explain != "" {
+		bsql, err := querySQL(config)
+		if err != nil {
+			return querySummary{}, err
+		}
+		f, err := os.Create(config.Explain)
+		if err != nil {
+			return querySummary{}, err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		exec = newExplainExecutor(exec, config.db, bsql, f, config.ExplainSample)
+	}
+
+	if config.OutputCSV != "" {
+		f, err := os.Create(config.OutputCSV)
+		if err != nil {
+			return querySummary{}, err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		config.resultsCSV = f
+	}
+	if config.DumpDurations != "" {
+		f, err := os.Create(config.DumpDurations)
+		if err != nil {
+			return querySummary{}, err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		config.durations = f
+	}
+	if config.Flamegraph != "" {
+		f, err := os.Create(config.Flamegraph)
+		if err != nil {
+			return querySummary{}, err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		config.flamegraph = f
+	}
 
-	start := time.Now()
-	summary, err := run(cli)
+	config.interim = os.Stderr
+	if config.ForceProgress || config.Progress && isTerminal(os.Stdout) {
+		config.progress = os.Stderr
+	}
+	if config.Verbose {
+		config.verbose = os.Stderr
+	}
+	config.skippedRows = os.Stderr
+	config.overlaps = os.Stderr
+	inputs, err := inputReaders(config)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return querySummary{}, err
 	}
-
-	fmt.Printf("Number of queries: %d\n", summary.count)
-	fmt.Printf("Total processing time: %v\n", summary.sum.Truncate(time.Microsecond))
-	fmt.Printf("Min / max processing time: %v / %v\n", summary.min.Truncate(time.Microsecond), summary.max.Truncate(time.Microsecond))
-	fmt.Printf("Mean / median processing time: %v / %v\n", summary.mean.Truncate(time.Microsecond), summary.median.Truncate(time.Microsecond))
-	fmt.Printf("Run time: %v\n", time.Since(start).Truncate(time.Microsecond))
-
-	os.Exit(0)
+	return runPipeline(ctx, config, inputs, exec)
 }
 
-func dbconnect(config *CLI) (*sql.DB, error) {
-	url := config.DBUrl
-	if url == "" {
-		format := "postgres://%s%s@%s:%d/%s"
-		password := ""
-		if config.Password != "" {
-			password = ":" + config.Password
-		}
-		url = fmt.Sprintf(format, config.Username, password, config.Host, config.Port, config.DBName)
-		if config.Host == "localhost" {
-			url += "?sslmode=disable"
+// runProbe probes the database, writing the outcome to stdout.
+func runProbe(config *CLI) error {
+	var exec *stmtExecutor
+	prepare := func() (queryExecutor, error) {
+		var err error
+		if exec, err = newStmtExecutor(config.db, config); err != nil {
+			return nil, err
 		}
+		return exec, nil
 	}
-	return sql.Open("pgx", url)
+	defer func() {
+		if exec != nil {
+			exec.close()
+		}
+	}()
+	names := newSchemaNames(config)
+	return probe(context.Background(), os.Stdout, sqlProbeDB{db: config.db, names: names}, names, prepare)
 }
 
-// run executes the tsbench data pipeline and returns the result. Currently
-// that result is just a count of input queries. As the program evolves, it
-// will be the result of the benchmark.
-func run(config *CLI) (querySummary, error) {
-	group, ctx := errgroup.WithContext(context.Background())
-	queries := make(chan query)
-	queryResults := make(chan queryResult)
+// runPipeline reads queries from inputs, executes them with exec and returns
+// a summary of the results. If parent is cancelled, such as when the run is
+// interrupted, the pipeline stops and the summary of the results so far is
+// returned marked as partial. If config.MaxDuration is set, the pipeline
+// stops in the same way once it has run that long, and the summary is marked
+// as time limited instead.
+func runPipeline(parent context.Context, config *CLI, inputs []io.Reader, exec queryExecutor) (querySummary, error) {
+	interrupted := parent
+	if config.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		parent, cancel = context.WithTimeout(parent, config.MaxDuration)
+		defer cancel()
+	}
+	group, ctx := errgroup.WithContext(parent)
+	// The stages up to --max-total-queries run on readCtx, which the limit
+	// cancels once it is reached so they do not block sending to it.
+	readCtx, stopReading := context.WithCancel(ctx)
+	defer stopReading()
+	queries := make(chan query, config.Buffer)
+	queryResults := make(chan queryResult, config.Buffer)
 
 	var summary querySummary
-	group.Go(func() error { return readQueries(ctx, config.Input, queries) })
-	group.Go(func() error { return executeQueries(ctx, config, queries, queryResults) })
+	var badRows int
+	group.Go(func() error {
+		err := readQueries(readCtx, config, inputs, queries)
+		var bre *badRowsError
+		if errors.As(err, &bre) {
+			// The run continues with the valid rows, so the error
+			// is reported after the summary.
+			badRows = bre.count
+			return nil
+		}
+		if err != nil {
+			return &inputError{err}
+		}
+		return nil
+	})
+	toExecute := queries
+	var overlaps int
+	if config.CheckOverlap {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			w := config.overlaps
+			if w == nil {
+				w = ioutil.Discard
+			}
+			overlaps = checkOverlaps(readCtx, w, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	if config.Sort {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			sortQueries(readCtx, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	var duplicates int
+	if config.Dedupe {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			duplicates = dedupeQueries(readCtx, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	var collapsed int
+	if config.ResultDedupWindow > 0 {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			collapsed = collapseQueries(readCtx, config.ResultDedupWindow, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	if config.Warmup > 0 {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			markWarmup(readCtx, config.Warmup, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	var capped bool
+	if config.MaxTotalQueries > 0 {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			capped = limitQueries(ctx, config.MaxTotalQueries, stopReading, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	if config.Rate > 0 {
+		in, out := toExecute, make(chan query, config.Buffer)
+		group.Go(func() error {
+			limitRate(ctx, config.Rate, in, out)
+			return nil
+		})
+		toExecute = out
+	}
+	var executed executeStats
+	group.Go(func() error {
+		var err error
+		if executed, err = executeQueries(ctx, config, exec, toExecute, queryResults); err != nil {
+			return &queryError{err}
+		}
+		return nil
+	})
+	toSummarise := queryResults
+	if config.resultsCSV != nil {
+		written := make(chan queryResult, config.Buffer)
+		group.Go(func() error {
+			return writeResults(ctx, config.resultsCSV, config.ResultsFlushInterval, queryResults, written)
+		})
+		toSummarise = written
+	}
+	if config.durations != nil {
+		in, out := toSummarise, make(chan queryResult, config.Buffer)
+		group.Go(func() error { return writeDurations(ctx, config.durations, in, out) })
+		toSummarise = out
+	}
+	if config.flamegraph != nil {
+		in, out := toSummarise, make(chan queryResult, config.Buffer)
+		group.Go(func() error { return writeFlamegraph(ctx, config.flamegraph, in, out) })
+		toSummarise = out
+	}
 	group.Go(func() error {
 		var err error
-		summary, err = summariseResults(ctx, queryResults)
+		summary, err = summariseResults(ctx, config, toSummarise)
 		return err
 	})
 
-	return summary, group.Wait()
+	err := group.Wait()
+	if parent.Err() != nil {
+		// The run was interrupted. Stages may fail as their queries are
+		// cancelled, but the results so far are still summarised.
+		err = nil
+		summary.partial = interrupted.Err() != nil
+		summary.timeLimited = !summary.partial
+	}
+	summary.capped = capped
+	summary.collapsed = collapsed
+	summary.badRows = badRows
+	summary.duplicates = duplicates
+	summary.overlaps = overlaps
+	summary.requestedRate = config.Rate
+	summary.backpressure = executed.backpressure
+	if !executed.start.IsZero() && summary.lastResult.After(executed.start) {
+		summary.throughput = float64(summary.count) / summary.lastResult.Sub(executed.start).Seconds()
+	}
+	return summary, err
 }
 
 // readQueries reads a CSV file of queries from input and sends each of them in
@@ -135,87 +1284,340 @@ func run(config *CLI) (querySummary, error) {
 // but not before sending any valid queries on the output channel.
 //
 // A well-formed CSV file has a header and each row with three columns:
-//   hostname: a string
-//   start_time: a time in the form YYYY-MM-DD HH:MM:SS
-//   end_time: a time in the form YYYY-MM-DD HH:MM:SS
-// The start and end time are in UTC.
-func readQueries(ctx context.Context, input io.Reader, output chan<- query) error {
+//
+//	hostname: a string
+//	start_time: a time in the form YYYY-MM-DD HH:MM:SS
+//	end_time: a time in the form YYYY-MM-DD HH:MM:SS
+//
+// The start and end time are in UTC, or the time zone config.InputTZ, and
+// are converted to UTC. If config.TimeFormat is set, the times are in that
+// layout instead, and keep any time zone offset they include. An optional
+// fourth column may be present:
+//
+//	expected_duration: a duration such as 10ms, or empty
+//
+// giving the maximum time the query is expected to take.
+//
+// The columns are found by their names in the header, so may be in any order
+// and other columns are ignored. The names of the hostname, start_time and
+// end_time columns can be changed in config.
+//
+// Each of inputs is such a file, read in turn. If config.Iterations is more
+// than one, the inputs are rewound and read again for each iteration. See
+// rewind.
+//
+// If config.Limit is set, reading stops once that many queries have been
+// sent, across all iterations.
+//
+// If config.SkipBadRows is set, invalid rows are skipped rather than ending
+// the input, and a badRowsError with the number of them across all inputs
+// and iterations is returned at the end.
+func readQueries(ctx context.Context, config *CLI, inputs []io.Reader, output chan<- query) error {
 	defer close(output)
 
-	r := csv.NewReader(input)
-	header, err := r.Read()
-	if err != nil {
-		return err
+	inputs = append([]io.Reader(nil), inputs...)
+	reopened := make([]io.Closer, len(inputs))
+	defer func() {
+		for _, c := range reopened {
+			if c != nil {
+				c.Close()
+			}
+		}
+	}()
+	remaining := config.Limit
+	skipped := 0
+	for i := 0; i < config.Iterations || i == 0; i++ {
+		for j, input := range inputs {
+			if i > 0 {
+				if ctx.Err() != nil {
+					return nil
+				}
+				r, err := rewind(input, config.ReopenInput)
+				if err != nil {
+					return nameInputError(input, len(inputs), err)
+				}
+				if r != input {
+					if reopened[j] != nil {
+						reopened[j].Close()
+					}
+					reopened[j] = r.(io.Closer)
+				}
+				inputs[j], input = r, r
+			}
+			sent, err := readCSV(ctx, config, input, output, remaining)
+			var bre *badRowsError
+			if errors.As(err, &bre) {
+				skipped += bre.count
+			} else if err != nil {
+				return nameInputError(input, len(inputs), err)
+			}
+			if config.Limit > 0 {
+				if remaining -= sent; remaining == 0 {
+					return skippedRowsError(skipped)
+				}
+			}
+		}
 	}
-	if len(header) != 3 || header[0] != "hostname" || header[1] != "start_time" || header[2] != "end_time" {
-		return fmt.Errorf("Unknown input format: %s", strings.Join(header, ", "))
+	return skippedRowsError(skipped)
+}
+
+// nameInputError returns err reading input. If there are n > 1 inputs and input
+// has a name, such as a file, err is prefixed with it to identify the input.
+func nameInputError(input io.Reader, n int, err error) error {
+	named, ok := input.(interface{ Name() string })
+	if n <= 1 || !ok {
+		return err
 	}
+	return fmt.Errorf("%s: %w", named.Name(), err)
+}
 
-	for line := 1; ; line++ {
-		row, err := r.Read()
-		if err == io.EOF {
-			return nil
+// rewind returns a reader for input from its start, to read it again. If
+// reopen is set and input is a file, the file is opened again by name and
+// the new file returned. This is for files that cannot seek, such as named
+// pipes. Otherwise, if input can seek, it is seeked to the start and
+// returned. Inputs that can do neither, such as stdin, return an error.
+func rewind(input io.Reader, reopen bool) (io.Reader, error) {
+	f, isFile := input.(*os.File)
+	if isFile && f == os.Stdin {
+		return nil, errors.New("cannot read stdin more than once for iterations")
+	}
+	if reopen && isFile {
+		return os.Open(f.Name())
+	}
+	if g, ok := input.(*gzipInput); ok && reopen {
+		f, err := os.Open(g.f.Name())
+		if err != nil {
+			return nil, err
 		}
+		g, err := newGzipInput(f)
 		if err != nil {
-			return err
+			f.Close()
+			return nil, err
 		}
+		return g, nil
+	}
+	seeker, ok := input.(io.Seeker)
+	if !ok {
+		return nil, errors.New("cannot read input more than once for iterations")
+	}
+	if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("cannot read input more than once for iterations (try --reopen-input): %w", err)
+	}
+	return input, nil
+}
 
-		q, err := newQuery(row)
-		if err != nil {
-			return fmt.Errorf("line %d: %w", line, err)
+// readCSV reads the CSV queries from input, as described for readQueries,
+// sending them to the output channel. If max is not zero, it stops after
+// sending max queries. It returns the number of queries sent.
+func readCSV(ctx context.Context, config *CLI, input io.Reader, output chan<- query, max int) (int, error) {
+	r := newCSVReader(config, input)
+	cols, err := readCSVColumns(config, r)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseCSV(ctx, config, cols, r, output, max)
+}
+
+// readCSVColumns reads the header of r and returns its columns. If
+// config.NoHeader is set, the input has no header, so nothing is read and
+// the columns are the hostname, start and end time in that order, which
+// every row must have.
+func readCSVColumns(config *CLI, r *csv.Reader) (csvColumns, error) {
+	if config.NoHeader {
+		r.FieldsPerRecord = 3
+		return csvColumns{hostname: 0, start: 1, end: 2, expected: -1}, nil
+	}
+	header, err := r.Read()
+	if err != nil {
+		return csvColumns{}, err
+	}
+	return newCSVColumns(config, header)
+}
+
+// newCSVReader returns a CSV reader of input with the field delimiter of
+// config. A UTF-8 byte order mark at the start of input is skipped.
+func newCSVReader(config *CLI, input io.Reader) *csv.Reader {
+	r := csv.NewReader(skipBOM(input))
+	if comma, err := csvDelimiter(config.Delimiter); err == nil {
+		r.Comma = comma
+	}
+	return r
+}
+
+// skipBOM returns a reader of input without the UTF-8 byte order mark it may
+// start with, as written by some Windows tools, which would otherwise be
+// part of the first header field.
+func skipBOM(input io.Reader) io.Reader {
+	br := bufio.NewReader(input)
+	if r, _, err := br.ReadRune(); err == nil && r != '\ufeff' {
+		br.UnreadRune()
+	}
+	return br
+}
+
+// csvDelimiter returns the field delimiter delim as a rune. It is a single
+// character, or \t for a tab. The empty string is a comma.
+func csvDelimiter(delim string) (rune, error) {
+	switch delim {
+	case "":
+		return ',', nil
+	case `\t`:
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(delim)
+	if size != len(delim) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid delimiter %q. must be a single character other than a quote or newline", delim)
+	}
+	return r, nil
+}
+
+// csvColumns are the indices of the columns in the rows of the input CSV
+// file. expected is -1 if there is no expected_duration column.
+type csvColumns struct {
+	hostname, start, end, expected int
+}
+
+// newCSVColumns returns the indices of the columns in header. The hostname,
+// start and end time columns are named by config.ColHostname, ColStart and
+// ColEnd, or hostname, start_time and end_time if those are not set, and may
+// be in any order. An error is returned if any of them is missing.
+func newCSVColumns(config *CLI, header []string) (csvColumns, error) {
+	index := map[string]int{}
+	for i, name := range header {
+		if _, ok := index[name]; !ok {
+			index[name] = i
 		}
-		if !sendQuery(ctx, q, output) {
-			return nil
+	}
+	cols := csvColumns{expected: -1}
+	for _, c := range []struct {
+		name, def string
+		col       *int
+	}{
+		{config.ColHostname, "hostname", &cols.hostname},
+		{config.ColStart, "start_time", &cols.start},
+		{config.ColEnd, "end_time", &cols.end},
+	} {
+		name := c.name
+		if name == "" {
+			name = c.def
 		}
+		i, ok := index[name]
+		if !ok {
+			return csvColumns{}, fmt.Errorf("Unknown input format: %s: missing column %s", strings.Join(header, ", "), name)
+		}
+		*c.col = i
+	}
+	if i, ok := index["expected_duration"]; ok {
+		cols.expected = i
 	}
+	return cols, nil
 }
 
-// newQuery returns a query struct from a CSV row. It is expected that the input
-// slice has 3 elements. If any of the fields are invalid, an error is returned.
-func newQuery(row []string) (query, error) {
-	if row[0] == "" {
-		return query{}, errors.New("empty hostname")
+// newQuery returns a query struct from a CSV row with the columns cols. If any
+// of the fields are invalid, an error is returned. If config.LowercaseHosts is
+// set, the hostname is lowercased. It is then translated by the hostname map,
+// if any; an unmapped hostname is kept as is unless config.HostnameMapStrict
+// is set, in which case it is an error. Spaces around the fields are
+// ignored.
+func newQuery(config *CLI, cols csvColumns, row []string) (query, error) {
+	hostname := strings.TrimSpace(row[cols.hostname])
+	startField := strings.TrimSpace(row[cols.start])
+	endField := strings.TrimSpace(row[cols.end])
+	var expectedField string
+	if cols.expected >= 0 {
+		expectedField = strings.TrimSpace(row[cols.expected])
+	}
+	if hostname == "" {
+		return query{}, &parseError{field: fieldHostname}
+	}
+	layout := config.TimeFormat
+	if layout == "" {
+		layout = timeLayout
 	}
-	start, err := time.Parse("2006-01-02 15:04:05", row[1])
+	loc := config.inputTZ
+	if loc == nil {
+		loc = time.UTC
+	}
+	start, err := time.ParseInLocation(layout, startField, loc)
 	if err != nil {
-		return query{}, fmt.Errorf("invalid start time: %s: %w", row[1], err)
+		return query{}, &parseError{fieldStart, startField, err}
 	}
-	end, err := time.Parse("2006-01-02 15:04:05", row[2])
+	end, err := time.ParseInLocation(layout, endField, loc)
 	if err != nil {
-		return query{}, fmt.Errorf("invalid start time: %s: %w", row[2], err)
+		return query{}, &parseError{fieldEnd, endField, err}
+	}
+	if start.After(end) {
+		return query{}, &parseError{fieldEnd, endField, fmt.Errorf("before start time %s", startField)}
+	}
+	// Times without a zone of their own are in loc and queried in UTC.
+	if start.Location() == loc {
+		start = start.UTC()
+	}
+	if end.Location() == loc {
+		end = end.UTC()
+	}
+	var expected time.Duration
+	if expectedField != "" {
+		expected, err = time.ParseDuration(expectedField)
+		if err != nil {
+			return query{}, &parseError{fieldExpected, expectedField, err}
+		}
+	}
+
+	if config.LowercaseHosts {
+		hostname = strings.ToLower(hostname)
+	}
+	if config.hostnameMap != nil {
+		mapped, ok := config.hostnameMap[hostname]
+		switch {
+		case ok:
+			hostname = mapped
+		case config.HostnameMapStrict:
+			return query{}, &parseError{fieldHostname, hostname, errUnmappedHostname}
+		}
 	}
 
-	return query{hostname: row[0], start: start, end: end}, nil
+	return query{hostname: hostname, start: start, end: end, expected: expected}, nil
 }
 
-func executeQueries(ctx context.Context, config *CLI, input <-chan query, output chan<- queryResult) error {
-	defer close(output)
+// executeStats are the statistics of executing the queries of a run.
+type executeStats struct {
+	// backpressure is the total backpressure of the workers sending
+	// results.
+	backpressure backpressure
 
-	sqlQ := "SELECT min(usage), max(usage) FROM cpu_usage WHERE host = $1 AND ts >= $2 AND ts <= $3"
-	stmt, err := config.db.PrepareContext(ctx, sqlQ)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
+	// start is when the first query was dispatched to a worker. It is
+	// zero if there were no queries.
+	start time.Time
+}
+
+// executeQueries executes the queries on the input channel with exec, sending
+// the results on the output channel. The queries are spread across
+// config.Workers concurrent workers, with all queries for a hostname going to
+// the same worker.
+func executeQueries(ctx context.Context, config *CLI, exec queryExecutor, input <-chan query, output chan<- queryResult) (executeStats, error) {
+	defer close(output)
 
 	workerGroup, gctx := errgroup.WithContext(ctx)
 	workers := make([]chan query, config.Workers)
+	blocked := make([]backpressure, config.Workers)
 	for i := 0; i < len(workers); i++ {
 		i := i // capture loop variable
 		workers[i] = make(chan query)
 		workerGroup.Go(func() error {
-			return worker(gctx, stmt, workers[i], output)
+			return worker(gctx, config, i, exec, workers[i], output, &blocked[i])
 		})
 	}
 
+	started := make(chan time.Time, 1)
 	go func() {
 		var q query
-		for recvQuery(ctx, &q, input) {
-			// Hash hostname to determine worker to use.
-			h := fnv.New32a()
-			h.Write([]byte(q.hostname)) //nolint:errcheck
-			workerNum := h.Sum32() % uint32(len(workers))
-			sendQuery(ctx, q, workers[workerNum])
+		for n := 0; recvQuery(ctx, &q, input); n++ {
+			if n == 0 {
+				started <- time.Now()
+			}
+			sendQuery(ctx, q, workers[workerIndex(q.hostname, len(workers))])
 		}
 
 		for _, w := range workers {
@@ -223,17 +1625,105 @@ func executeQueries(ctx context.Context, config *CLI, input <-chan query, output
 		}
 	}()
 
-	return workerGroup.Wait()
+	err := workerGroup.Wait()
+	var stats executeStats
+	for _, b := range blocked {
+		stats.backpressure.blocked += b.blocked
+		stats.backpressure.blockedTime += b.blockedTime
+	}
+	select {
+	case stats.start = <-started:
+	default:
+	}
+	return stats, err
+}
+
+// workerIndex returns the index of the worker of n that executes the queries
+// for hostname. It is the 32-bit FNV-1a hash of the hostname modulo n, so it
+// depends only on the hostname and the number of workers, not on the order
+// of the input or the Go version, and a hostname is executed by the same
+// worker in every run with the same number of workers.
+func workerIndex(hostname string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(hostname)) //nolint:errcheck
+	return int(h.Sum32() % uint32(n))
 }
 
-func worker(ctx context.Context, stmt *sql.Stmt, input <-chan query, output chan<- queryResult) error {
+// worker executes each query on the input channel with exec and sends the
+// result on the output channel.
+//
+// If config.PlanWarmup is set, the first query of each distinct query shape
+// is executed once before it is timed, to warm the plan cache of the
+// database connection. The warmup is sent as a planWarmup result. As each
+// worker may use a different connection, every worker warms each shape.
+//
+// If config.StallTimeout is set and a query takes longer than that, the
+// query is abandoned and its hostname is marked as skipped. That query and
+// all subsequent queries for the same hostname are sent as skipped results
+// without being executed. As all queries for a hostname go to the same
+// worker, this drains the queries for a stalled host while other workers
+// continue.
+//
+// If config.ContinueOnError is set, a query that fails is sent as a result
+// with its error instead of stopping the worker.
+//
+// If config.QueryTimeout is set, a query taking longer than that is
+// abandoned and sent as a timedOut result, and the worker continues with the
+// next query.
+//
+// If config.BatchSize is more than one and exec is a batcher, the queries are
+// executed in batches by batchWorker instead.
+//
+// Each result is tagged with id, the number of the worker. The time spent
+// blocked sending each result is added to blocked. If config.verbose is set,
+// each result is logged to it before it is sent. See logResult.
+func worker(ctx context.Context, config *CLI, id int, exec queryExecutor, input <-chan query, output chan<- queryResult, blocked *backpressure) error {
+	if b, ok := exec.(batcher); ok && config.BatchSize > 1 {
+		return batchWorker(ctx, config, id, b, input, output, blocked)
+	}
+
+	skipped := map[string]bool{}
+	warmed := map[string]bool{}
+	timed := queryExecutorFunc(func(ctx context.Context, q query) (queryResult, error) {
+		return executeWithTimeout(ctx, config.QueryTimeout, exec, q)
+	})
 	var q query
 	for recvQuery(ctx, &q, input) {
-		qr, err := executeQuery(stmt, q)
-		if err != nil {
-			return err
+		if config.PlanWarmup && !warmed[queryShape(exec, q)] {
+			warmed[queryShape(exec, q)] = true
+			if _, err := exec.executeQuery(ctx, q); err != nil {
+				return err
+			}
+			ok, d := sendQueryResultTimed(ctx, queryResult{query: q, worker: id, planWarmup: true}, output)
+			blocked.add(d)
+			if !ok {
+				return nil
+			}
+		}
+
+		var qr queryResult
+		var err error
+		if skipped[q.hostname] {
+			qr = queryResult{query: q, skipped: true}
+		} else {
+			qr, err = executeStallable(ctx, config.StallTimeout, timed, q)
+			if err != nil && config.ContinueOnError && ctx.Err() == nil {
+				qr, err = queryResult{query: q, err: err}, nil
+			}
+			if err != nil {
+				return err
+			}
+			if qr.skipped {
+				skipped[q.hostname] = true
+			}
+		}
+		qr.worker = id
+		if config.verbose != nil {
+			logResult(config.verbose, qr)
 		}
-		if !sendQueryResult(ctx, qr, output) {
+		ok, d := sendQueryResultTimed(ctx, qr, output)
+		blocked.add(d)
+		if !ok {
 			return nil
 		}
 	}
@@ -241,30 +1731,332 @@ func worker(ctx context.Context, stmt *sql.Stmt, input <-chan query, output chan
 	return nil
 }
 
-func executeQuery(stmt *sql.Stmt, q query) (queryResult, error) {
-	var qr queryResult
+// logResult writes qr to w as a line of space-separated key=value pairs, e.g.
+//
+//	query worker=0 hostname=host_000008 start=2017-01-01T08:59:22Z end=2017-01-01T09:59:22Z status=ok duration=1.2ms
+//
+// The status is ok, skipped, timed_out or error, with the error quoted in an
+// error field. Only ok results have a duration.
+func logResult(w io.Writer, qr queryResult) {
+	q := qr.query
+	line := fmt.Sprintf("query worker=%d hostname=%s start=%s end=%s", qr.worker, q.hostname,
+		q.start.Format(time.RFC3339), q.end.Format(time.RFC3339))
+	switch {
+	case qr.skipped:
+		line += " status=skipped"
+	case qr.timedOut:
+		line += " status=timed_out"
+	case qr.err != nil:
+		line += fmt.Sprintf(" status=error error=%q", qr.err.Error())
+	default:
+		line += fmt.Sprintf(" status=ok duration=%s", qr.queryDuration)
+	}
+	fmt.Fprintln(w, line)
+}
+
+// executeStallable executes q with exec, abandoning it if it takes longer
+// than timeout. An abandoned query is returned as a skipped result. A zero
+// timeout means the query is never abandoned.
+func executeStallable(ctx context.Context, timeout time.Duration, exec queryExecutor, q query) (queryResult, error) {
+	if timeout == 0 {
+		return exec.executeQuery(ctx, q)
+	}
+	qctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	qr, err := exec.executeQuery(qctx, q)
+	if err != nil && qctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return queryResult{query: q, skipped: true}, nil
+	}
+	return qr, err
+}
+
+// executeWithTimeout executes q with exec, abandoning it if it takes longer
+// than timeout. An abandoned query is returned as a timedOut result. A zero
+// timeout means the query is never abandoned.
+func executeWithTimeout(ctx context.Context, timeout time.Duration, exec queryExecutor, q query) (queryResult, error) {
+	if timeout == 0 {
+		return exec.executeQuery(ctx, q)
+	}
+	qctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	qr, err := exec.executeQuery(qctx, q)
+	if err != nil && qctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return queryResult{query: q, timedOut: true}, nil
+	}
+	return qr, err
+}
+
+// queryExecutor executes a single query against the database.
+type queryExecutor interface {
+	executeQuery(ctx context.Context, q query) (queryResult, error)
+}
+
+// queryExecutorFunc is a function that is a queryExecutor.
+type queryExecutorFunc func(ctx context.Context, q query) (queryResult, error)
+
+func (f queryExecutorFunc) executeQuery(ctx context.Context, q query) (queryResult, error) {
+	return f(ctx, q)
+}
+
+// shaper is implemented by a queryExecutor that executes queries with
+// different shapes, i.e. different SQL statements that are planned
+// separately by the database.
+type shaper interface {
+	queryShape(q query) string
+}
+
+// queryShape returns the shape of q when executed by exec. If exec does not
+// implement shaper, all queries have the same shape.
+func queryShape(exec queryExecutor, q query) string {
+	if s, ok := exec.(shaper); ok {
+		return s.queryShape(q)
+	}
+	return ""
+}
+
+// stmtExecutor is a queryExecutor that executes queries using a prepared
+// statement.
+type stmtExecutor struct {
+	stmt *sql.Stmt
+	bsql benchmarkSQL
+
+	// db executes batches of queries, whose statements vary with the
+	// size of the batch and so are not prepared.
+	db queryer
+}
+
+// newStmtExecutor prepares the benchmark query for config on db and returns
+// a stmtExecutor for it. It should be closed when no longer needed.
+func newStmtExecutor(db *sql.DB, config *CLI) (*stmtExecutor, error) {
+	bsql, err := querySQL(config)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := db.Prepare(bsql.text)
+	if err != nil {
+		return nil, err
+	}
+	return &stmtExecutor{stmt: stmt, bsql: bsql, db: db}, nil
+}
+
+func (e *stmtExecutor) close() error {
+	return e.stmt.Close()
+}
+
+func (e *stmtExecutor) executeQuery(ctx context.Context, q query) (queryResult, error) {
+	qr := queryResult{query: q}
 	qStart := time.Now()
 
-	row := stmt.QueryRow(q.hostname, q.start, q.end)
-	if err := row.Scan(&qr.minCPU, &qr.maxCPU); err != nil {
+	rows, err := e.stmt.QueryContext(ctx, e.bsql.args(q)...)
+	if err != nil {
+		return queryResult{}, err
+	}
+	defer rows.Close()
+	qr.executeDuration = time.Since(qStart)
+
+	if e.bsql.buckets {
+		if err := readBuckets(rows, qStart, &qr); err != nil {
+			return queryResult{}, err
+		}
+		qr.queryDuration = time.Since(qStart)
+		return qr, nil
+	}
+
+	var dest []interface{}
+	var minCPU, maxCPU, value sql.NullFloat64
+	switch {
+	case e.bsql.minMax:
+		dest = []interface{}{&minCPU, &maxCPU}
+	case e.bsql.aggregate:
+		dest = []interface{}{&value}
+	}
+	if qr.firstRowDuration, err = readRows(rows, qStart, dest...); err != nil {
 		return queryResult{}, err
 	}
+	switch {
+	case e.bsql.minMax:
+		// The aggregates are NULL if there is no data in the window.
+		qr.minCPU, qr.maxCPU = minCPU.Float64, maxCPU.Float64
+		qr.noData = !minCPU.Valid
+		qr.hasCPU = minCPU.Valid
+	case e.bsql.aggregate:
+		// There is always a row, so the aggregate tells if there
+		// was data.
+		qr.noData = e.bsql.noData(value)
+	default:
+		qr.noData = qr.firstRowDuration == 0
+	}
 
 	qr.queryDuration = time.Since(qStart)
 	return qr, nil
 }
 
+// resultRows is the subset of *sql.Rows used to read the result of a query.
+type resultRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// readRows reads all of rows, scanning the first row into dest if given. The
+// other rows are read and discarded. It returns the time from start until the
+// first row was available, or zero if there are no rows. If dest is given and
+// there are no rows, sql.ErrNoRows is returned.
+func readRows(rows resultRows, start time.Time, dest ...interface{}) (time.Duration, error) {
+	var firstRow time.Duration
+	first := true
+	for rows.Next() {
+		if !first {
+			continue
+		}
+		first = false
+		firstRow = time.Since(start)
+		if len(dest) > 0 {
+			if err := rows.Scan(dest...); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if first && len(dest) > 0 {
+		return 0, sql.ErrNoRows
+	}
+	return firstRow, nil
+}
+
 // summariseResults tallies all the query results on the input channel and
 // returns out a summary including the number of queries, total processing
 // tme and the min, max, mean and median processing time.
-func summariseResults(ctx context.Context, input <-chan queryResult) (querySummary, error) {
+//
+// If config.ResultsLimit is set, at most that many results are retained for
+// calculating the median and percentiles, using reservoir sampling to keep a
+// uniform random sample of all results. The count, total, min, max and mean
+// are always exact.
+//
+// If config.ApproxQuantiles is set, no results are retained and the median
+// and percentiles are estimated in constant memory instead.
+func summariseResults(ctx context.Context, config *CLI, input <-chan queryResult) (querySummary, error) {
 	summary := querySummary{}
 	results := []queryResult{}
 
-	var qr queryResult
-	for recvQueryResult(ctx, &qr, input) {
-		results = append(results, qr)
+	skippedHosts := map[string]bool{}
+	hosts := map[string]struct{}{}
+	workers := map[int]*workerSummary{}
+	var firstRowSum time.Duration
+	// mean and m2 are the running mean and sum of squared differences
+	// from the mean of the durations in nanoseconds, for the standard
+	// deviation by Welford's method.
+	var mean, m2 float64
+	// logSum is the sum of the natural logarithms of the non-zero
+	// durations in nanoseconds, and logCount the number of them, for the
+	// geometric mean without overflowing their product.
+	var logSum float64
+	var logCount int
+	var slowest *slowestResults
+	if config.TopSlow > 0 {
+		slowest = newSlowestResults(config.TopSlow)
+	}
+	var checksum *resultChecksum
+	if config.Checksum {
+		checksum = &resultChecksum{}
+	}
+
+	start := time.Now()
+	var tick <-chan time.Time
+	if config.SummaryInterval > 0 && config.interim != nil {
+		ticker := time.NewTicker(config.SummaryInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var progressTick <-chan time.Time
+	if config.progress != nil {
+		ticker := time.NewTicker(config.ProgressInterval)
+		defer ticker.Stop()
+		progressTick = ticker.C
+		defer func() {
+			// Show the final count and end the line for further output.
+			printProgress(config.progress, summary)
+			fmt.Fprintln(config.progress)
+		}()
+	}
+	var quantiles *summaryQuantiles
+	if config.ApproxQuantiles {
+		quantiles = newSummaryQuantiles()
+	}
+	var window *statsWindow
+	if config.StatsWindow > 0 {
+		window = newStatsWindow(config.StatsWindow)
+	}
+	durations := newDurationFormat(config.Unit, config.Precision)
+
+	for {
+		var qr queryResult
+		var ok bool
+		select {
+		case <-ctx.Done():
+		case now := <-tick:
+			if window != nil {
+				stats := newInterimStats(window.results(now), minDuration(config.StatsWindow, now.Sub(start)), config.PercentileMethod)
+				printInterim(config.interim, stats, config.StatsWindow, durations)
+			} else {
+				// The running stats are tallied as the results
+				// are received, so only the p99 needs the
+				// retained results.
+				stats := runningInterimStats(summary, now.Sub(start))
+				if quantiles != nil {
+					stats.p99 = quantiles.p99()
+				} else {
+					stats.p99 = newInterimStats(results, 0, config.PercentileMethod).p99
+				}
+				printInterim(config.interim, stats, 0, durations)
+			}
+			continue
+		case <-progressTick:
+			printProgress(config.progress, summary)
+			continue
+		case qr, ok = <-input:
+		}
+		if !ok {
+			break
+		}
+		summary.lastResult = time.Now()
+		hosts[qr.query.hostname] = struct{}{}
+
+		if qr.planWarmup {
+			summary.planWarmups++
+			continue
+		}
+		if qr.query.warmup {
+			summary.warmups++
+			continue
+		}
+		if qr.skipped {
+			summary.skipped++
+			skippedHosts[qr.query.hostname] = true
+			continue
+		}
+		if qr.timedOut {
+			summary.timedOut++
+			continue
+		}
+		if qr.err != nil {
+			if summary.errors == 0 {
+				summary.firstError = qr.err
+			}
+			summary.errors++
+			continue
+		}
 		summary.count++
+		if quantiles != nil {
+			quantiles.add(qr.queryDuration)
+		} else {
+			results = retainResult(results, qr, summary.count, config.ResultsLimit)
+		}
+		if window != nil {
+			window.add(time.Now(), qr)
+		}
 		if qr.queryDuration < summary.min || summary.min == 0 {
 			summary.min = qr.queryDuration
 		}
@@ -272,21 +2064,166 @@ func summariseResults(ctx context.Context, input <-chan queryResult) (querySumma
 			summary.max = qr.queryDuration
 		}
 		summary.sum += qr.queryDuration
+		firstRowSum += qr.firstRowDuration
+		delta := float64(qr.queryDuration) - mean
+		mean += delta / float64(summary.count)
+		m2 += delta * (float64(qr.queryDuration) - mean)
+		if qr.queryDuration > 0 {
+			logSum += math.Log(float64(qr.queryDuration))
+			logCount++
+		}
+		summary.latency.observe(qr)
+		if slowest != nil {
+			slowest.observe(qr)
+		}
+		if checksum != nil {
+			checksum.observe(qr)
+		}
+		if config.PerWorker {
+			ws, ok := workers[qr.worker]
+			if !ok {
+				ws = &workerSummary{id: qr.worker}
+				workers[qr.worker] = ws
+			}
+			ws.count++
+			ws.sum += qr.queryDuration
+			if qr.queryDuration < ws.min || ws.min == 0 {
+				ws.min = qr.queryDuration
+			}
+			if qr.queryDuration > ws.max {
+				ws.max = qr.queryDuration
+			}
+		}
+		if qr.noData {
+			summary.noData++
+		}
+		summary.retries += qr.retries
+		if qr.hasCPU {
+			if qr.minCPU < summary.overallMinCPU || !summary.cpuObserved {
+				summary.overallMinCPU = qr.minCPU
+			}
+			if qr.maxCPU > summary.overallMaxCPU || !summary.cpuObserved {
+				summary.overallMaxCPU = qr.maxCPU
+			}
+			summary.cpuObserved = true
+		}
+		if config.MaxCPUUsage > 0 && qr.maxCPU > config.MaxCPUUsage {
+			summary.overMaxCPU = append(summary.overMaxCPU, qr)
+		}
+		if qr.query.expected != 0 {
+			summary.sloChecked = true
+			if qr.queryDuration > qr.query.expected {
+				summary.sloBreaches = append(summary.sloBreaches, qr)
+			}
+		}
+	}
+
+	for host := range skippedHosts {
+		summary.skippedHosts = append(summary.skippedHosts, host)
+	}
+	sort.Strings(summary.skippedHosts)
+	summary.distinctHosts = len(hosts)
+	for _, ws := range workers {
+		summary.workers = append(summary.workers, *ws)
 	}
+	sort.Slice(summary.workers, func(i, j int) bool {
+		return summary.workers[i].id < summary.workers[j].id
+	})
 
+	// If ctx was cancelled, the results so far are still summarised so
+	// the summary is consistent, but marked as partial.
+	summary.partial = ctx.Err() != nil
+	summary.retained = len(results)
+	if slowest != nil {
+		summary.slowest = slowest.slowest()
+	}
+	if checksum != nil {
+		summary.checksum = checksum.sum()
+	}
+	if summary.count == 0 {
+		return summary, nil
+	}
 	summary.mean = time.Duration(int64(summary.sum) / int64(summary.count))
+	summary.firstRowMean = time.Duration(int64(firstRowSum) / int64(summary.count))
+	summary.stddev = time.Duration(math.Round(math.Sqrt(m2 / float64(summary.count))))
+	if logCount > 0 {
+		summary.geomean = time.Duration(math.Round(math.Exp(logSum / float64(logCount))))
+	}
+	if quantiles != nil {
+		summary.approx = true
+		summary.median = quantiles.median()
+		summary.p95 = quantiles.p95()
+		summary.p99 = quantiles.p99()
+		return summary, nil
+	}
 	summary.median = calculateMedian(results)
+	summary.results = results
+	summary.p95 = calculatePercentile(results, 95, config.PercentileMethod)
+	summary.p99 = calculatePercentile(results, 99, config.PercentileMethod)
 
 	return summary, nil
 }
 
+// minDuration returns the lesser of a and b.
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// retainResult adds qr, the n-th result seen, to results unless there are
+// already limit results retained, in which case qr replaces a random retained
+// result with probability limit/n (reservoir sampling). A limit of zero means
+// all results are retained.
+func retainResult(results []queryResult, qr queryResult, n, limit int) []queryResult {
+	if limit <= 0 || len(results) < limit {
+		return append(results, qr)
+	}
+	if i := rand.Intn(n); i < limit {
+		results[i] = qr
+	}
+	return results
+}
+
 func calculateMedian(results []queryResult) time.Duration {
 	sort.Slice(results, func(i, j int) bool {
 		return results[i].queryDuration < results[j].queryDuration
 	})
 	count := len(results)
+	if count == 0 {
+		return 0
+	}
 	if count%2 == 0 {
 		return (results[(count/2)-1].queryDuration + results[count/2].queryDuration) / 2
 	}
 	return results[count/2].queryDuration
 }
+
+// calculatePercentile returns the p-th percentile query duration of results
+// using method, either "nearest" or "linear". results must already be sorted
+// by duration, as done by calculateMedian.
+//
+// The nearest-rank method returns the smallest duration such that at least
+// p percent of results are no longer than it, so it is always one of the
+// measured durations. The linear method interpolates between the two
+// closest ranks, which gives smoother estimates on small samples. The two
+// methods agree more closely as the number of results grows.
+func calculatePercentile(results []queryResult, p float64, method string) time.Duration {
+	if len(results) == 0 {
+		return 0
+	}
+	if method == "linear" {
+		rank := p / 100 * float64(len(results)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		frac := rank - float64(lower)
+		lo, hi := results[lower].queryDuration, results[upper].queryDuration
+		return lo + time.Duration(math.Round(frac*float64(hi-lo)))
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(results))))
+	if rank < 1 {
+		rank = 1
+	}
+	return results[rank-1].queryDuration
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// defaultLatencyBuckets are the upper bounds of the latency histogram
+// buckets, the same as the default buckets of the Prometheus client
+// libraries. There is an implicit final +Inf bucket.
+var defaultLatencyBuckets = []time.Duration{
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// latencyHistogram counts query durations in buckets, keeping the slowest
+// result in each bucket to use as an exemplar. The zero value is a histogram
+// with the default buckets.
+type latencyHistogram struct {
+	// bounds are the upper bounds of the buckets, excluding +Inf.
+	bounds []time.Duration
+	// counts and slowest are the number of results and the slowest result
+	// in each bucket, including +Inf. Counts are not cumulative.
+	counts  []int
+	slowest []queryResult
+}
+
+// observe adds qr to the bucket for its query duration.
+func (h *latencyHistogram) observe(qr queryResult) {
+	if h.counts == nil {
+		if h.bounds == nil {
+			h.bounds = defaultLatencyBuckets
+		}
+		h.counts = make([]int, len(h.bounds)+1)
+		h.slowest = make([]queryResult, len(h.bounds)+1)
+	}
+	i := 0
+	for i < len(h.bounds) && qr.queryDuration > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	if h.counts[i] == 1 || qr.queryDuration > h.slowest[i].queryDuration {
+		h.slowest[i] = qr
+	}
+}
+
+// writeMetricsFile writes summary to the file filename in the text format
+// format, openmetrics or prometheus. See writeMetrics.
+func writeMetricsFile(filename, format string, summary querySummary) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return writeMetrics(f, format, summary)
+}
+
+// writeMetrics writes the latency histogram of summary and its median, p95
+// and p99 query durations as a summary metric to w, in OpenMetrics text
+// format if format is openmetrics, or otherwise the Prometheus text
+// exposition format, e.g. for a Pushgateway.
+//
+// In OpenMetrics format, each non-empty bucket of the histogram has an
+// exemplar of the slowest query in that bucket, labelled with its host and
+// time window, so a latency spike can be traced to a specific query. The
+// Prometheus format has no exemplars.
+func writeMetrics(w io.Writer, format string, summary querySummary) error {
+	openMetrics := format == "openmetrics"
+	bw := bufio.NewWriter(w)
+	header := func(name, typ string) {
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, typ)
+		if openMetrics {
+			fmt.Fprintf(bw, "# UNIT %s seconds\n", name)
+		}
+		fmt.Fprintf(bw, "# HELP %s Time taken to execute a query.\n", name)
+	}
+
+	const name = "tsbench_query_latency_seconds"
+	h := summary.latency
+	header(name, "histogram")
+	cumulative := 0
+	for i := range h.counts {
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatSeconds(h.bounds[i])
+		}
+		cumulative += h.counts[i]
+		fmt.Fprintf(bw, "%s_bucket{le=%q} %d", name, le, cumulative)
+		if h.counts[i] > 0 && openMetrics {
+			q := h.slowest[i].query
+			window := q.start.UTC().Format(time.RFC3339) + "/" + q.end.UTC().Format(time.RFC3339)
+			fmt.Fprintf(bw, " # {host=%q,window=%q} %s", q.hostname, window, formatSeconds(h.slowest[i].queryDuration))
+		}
+		fmt.Fprintln(bw)
+	}
+	if h.counts == nil {
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} 0\n", name)
+	}
+	fmt.Fprintf(bw, "%s_count %d\n", name, summary.count)
+	fmt.Fprintf(bw, "%s_sum %s\n", name, formatSeconds(summary.sum))
+
+	const summaryName = "tsbench_query_duration_seconds"
+	header(summaryName, "summary")
+	for _, q := range []struct {
+		quantile string
+		d        time.Duration
+	}{{"0.5", summary.median}, {"0.95", summary.p95}, {"0.99", summary.p99}} {
+		fmt.Fprintf(bw, "%s{quantile=%q} %s\n", summaryName, q.quantile, formatSeconds(q.d))
+	}
+	fmt.Fprintf(bw, "%s_count %d\n", summaryName, summary.count)
+	fmt.Fprintf(bw, "%s_sum %s\n", summaryName, formatSeconds(summary.sum))
+	if openMetrics {
+		fmt.Fprintln(bw, "# EOF")
+	}
+	return bw.Flush()
+}
+
+// formatSeconds formats d as a number of seconds for metrics output.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
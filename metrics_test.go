@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOpenMetrics(t *testing.T) {
+	results := []queryResult{
+		{query: good1Query, queryDuration: 3 * time.Millisecond},
+		{query: good2Query, queryDuration: 4 * time.Millisecond},
+		{query: good1Query, queryDuration: 40 * time.Millisecond},
+		{query: good2Query, queryDuration: 20 * time.Second},
+	}
+	summary, err := summarise(results...)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeMetrics(&buf, "openmetrics", summary))
+	want := `# TYPE tsbench_query_latency_seconds histogram
+# UNIT tsbench_query_latency_seconds seconds
+# HELP tsbench_query_latency_seconds Time taken to execute a query.
+tsbench_query_latency_seconds_bucket{le="0.005"} 2 # {host="host_000001",window="2017-01-02T13:02:02Z/2017-01-02T14:02:02Z"} 0.004
+tsbench_query_latency_seconds_bucket{le="0.01"} 2
+tsbench_query_latency_seconds_bucket{le="0.025"} 2
+tsbench_query_latency_seconds_bucket{le="0.05"} 3 # {host="host_000008",window="2017-01-01T08:59:22Z/2017-01-01T09:59:22Z"} 0.04
+tsbench_query_latency_seconds_bucket{le="0.1"} 3
+tsbench_query_latency_seconds_bucket{le="0.25"} 3
+tsbench_query_latency_seconds_bucket{le="0.5"} 3
+tsbench_query_latency_seconds_bucket{le="1"} 3
+tsbench_query_latency_seconds_bucket{le="2.5"} 3
+tsbench_query_latency_seconds_bucket{le="5"} 3
+tsbench_query_latency_seconds_bucket{le="10"} 3
+tsbench_query_latency_seconds_bucket{le="+Inf"} 4 # {host="host_000001",window="2017-01-02T13:02:02Z/2017-01-02T14:02:02Z"} 20
+tsbench_query_latency_seconds_count 4
+tsbench_query_latency_seconds_sum 20.047
+# TYPE tsbench_query_duration_seconds summary
+# UNIT tsbench_query_duration_seconds seconds
+# HELP tsbench_query_duration_seconds Time taken to execute a query.
+tsbench_query_duration_seconds{quantile="0.5"} 0.022
+tsbench_query_duration_seconds{quantile="0.95"} 20
+tsbench_query_duration_seconds{quantile="0.99"} 20
+tsbench_query_duration_seconds_count 4
+tsbench_query_duration_seconds_sum 20.047
+# EOF
+`
+	require.Equal(t, want, buf.String())
+}
+
+func TestWritePrometheusMetrics(t *testing.T) {
+	summary, err := summarise(
+		queryResult{query: good1Query, queryDuration: 3 * time.Millisecond},
+		queryResult{query: good2Query, queryDuration: 40 * time.Millisecond},
+	)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeMetrics(&buf, "prometheus", summary))
+	out := buf.String()
+	require.Contains(t, out, "tsbench_query_latency_seconds_bucket{le=\"0.005\"} 1\n")
+	require.Contains(t, out, "# TYPE tsbench_query_duration_seconds summary\n")
+	require.Contains(t, out, "tsbench_query_duration_seconds{quantile=\"0.99\"} 0.04\n")
+	require.Contains(t, out, "tsbench_query_duration_seconds_count 2\n")
+	require.NotContains(t, out, "# {")
+	require.NotContains(t, out, "# UNIT")
+	require.NotContains(t, out, "# EOF")
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// palette colours text with ANSI escape codes for terminal output. The zero
+// value leaves text uncoloured.
+type palette struct {
+	enabled bool
+}
+
+// newPalette returns a palette for writing to f with the colour mode
+// "always", "never" or "auto". In auto mode text is coloured only if f is a
+// terminal.
+func newPalette(mode string, f *os.File) palette {
+	switch mode {
+	case "always":
+		return palette{enabled: true}
+	case "auto":
+		return palette{enabled: isTerminal(f)}
+	}
+	return palette{}
+}
+
+// isTerminal returns true if f is a terminal (character device).
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func (p palette) red(s string) string    { return p.colour("31", s) }
+func (p palette) green(s string) string  { return p.colour("32", s) }
+func (p palette) yellow(s string) string { return p.colour("33", s) }
+
+func (p palette) colour(code, s string) string {
+	if !p.enabled {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// durationFormat formats durations for display. The zero value formats them
+// truncated to the microsecond in the most suitable unit, such as 1.5ms.
+type durationFormat struct {
+	// unit is the unit all durations are displayed in, with suffix, and
+	// precision decimal places. It is 0 for the default format.
+	unit      time.Duration
+	suffix    string
+	precision int
+}
+
+// durationUnits are the units that can be chosen for displaying durations,
+// by name, and their suffixes.
+var durationUnits = map[string]struct {
+	unit   time.Duration
+	suffix string
+}{
+	"ms": {time.Millisecond, "ms"},
+	"us": {time.Microsecond, "µs"},
+	"ns": {time.Nanosecond, "ns"},
+}
+
+// newDurationFormat returns the durationFormat displaying durations in the
+// named unit, "ms", "us" or "ns", with precision decimal places. Any other
+// unit, such as "auto", is the default format and precision is ignored.
+func newDurationFormat(unit string, precision int) durationFormat {
+	u, ok := durationUnits[unit]
+	if !ok {
+		return durationFormat{}
+	}
+	return durationFormat{unit: u.unit, suffix: u.suffix, precision: precision}
+}
+
+func (f durationFormat) format(d time.Duration) string {
+	if f.unit == 0 {
+		return d.Truncate(time.Microsecond).String()
+	}
+	return fmt.Sprintf("%.*f%s", f.precision, float64(d)/float64(f.unit), f.suffix)
+}
+
+// formatRounded is as format, except the default format rounds d with
+// roundDuration for concise display.
+func (f durationFormat) formatRounded(d time.Duration) string {
+	if f.unit == 0 {
+		return roundDuration(d).String()
+	}
+	return f.format(d)
+}
+
+// printSummary writes summary to w in a human readable multi-line form, with
+// durations formatted by f. Problems such as SLO breaches are highlighted
+// with the colours of p.
+func printSummary(w io.Writer, summary querySummary, f durationFormat, p palette) {
+	fmt.Fprintf(w, "Number of queries: %d\n", summary.count)
+	fmt.Fprintf(w, "Distinct hosts: %d\n", summary.distinctHosts)
+	fmt.Fprintf(w, "Total processing time: %v\n", f.format(summary.sum))
+	fmt.Fprintf(w, "Min / max processing time: %v / %v\n", f.format(summary.min), f.format(summary.max))
+	fmt.Fprintf(w, "Mean / geometric mean / median processing time: %v / %v / %v\n", f.format(summary.mean),
+		f.format(summary.geomean), f.format(summary.median))
+	fmt.Fprintf(w, "Standard deviation of processing time: %v\n", f.format(summary.stddev))
+	fmt.Fprintf(w, "p95 / p99 processing time: %v / %v\n", f.format(summary.p95), f.format(summary.p99))
+	fmt.Fprintf(w, "Mean time to first row / total fetch: %v / %v\n", f.format(summary.firstRowMean), f.format(summary.mean))
+	fmt.Fprintf(w, "Run time: %v\n", f.format(summary.elapsed))
+	fmt.Fprintf(w, "Throughput: %.1f queries/s\n", summary.throughput)
+	if summary.checksum != "" {
+		fmt.Fprintf(w, "Result checksum: %s\n", summary.checksum)
+	}
+	if summary.requestedRate > 0 {
+		fmt.Fprintf(w, "Requested rate: %.1f queries/s\n", summary.requestedRate)
+	}
+	if summary.cpuObserved {
+		fmt.Fprintf(w, "Min / max CPU usage: %g / %g\n", summary.overallMinCPU, summary.overallMaxCPU)
+	}
+	if summary.partial {
+		fmt.Fprintln(w, p.yellow("Run cancelled: summary is of the queries completed"))
+	}
+	if summary.timeLimited {
+		fmt.Fprintln(w, p.yellow("Run stopped at maximum duration"))
+	}
+	if summary.capped {
+		fmt.Fprintln(w, p.yellow("Run stopped at maximum total queries"))
+	}
+	if summary.duplicates > 0 {
+		fmt.Fprintf(w, "Duplicate queries skipped: %d\n", summary.duplicates)
+	}
+	if summary.badRows > 0 {
+		fmt.Fprintln(w, p.red(fmt.Sprintf("Bad input rows skipped: %d", summary.badRows)))
+	}
+	if summary.overlaps > 0 {
+		fmt.Fprintln(w, p.yellow(fmt.Sprintf("Overlapping query windows: %d", summary.overlaps)))
+	}
+	if summary.collapsed > 0 {
+		fmt.Fprintf(w, "Collapsed near-duplicate queries: %d\n", summary.collapsed)
+	}
+	if summary.approx {
+		fmt.Fprintln(w, "Median and percentiles estimated by streaming quantile estimators")
+	} else if summary.retained < summary.count {
+		fmt.Fprintf(w, "Median and percentiles estimated from a sample of %d results\n", summary.retained)
+	}
+	if summary.sloChecked {
+		line := fmt.Sprintf("SLO breaches: %d", len(summary.sloBreaches))
+		if len(summary.sloBreaches) > 0 {
+			fmt.Fprintln(w, p.red(line))
+		} else {
+			fmt.Fprintln(w, p.green(line))
+		}
+		for _, qr := range summary.sloBreaches {
+			fmt.Fprintln(w, p.red(fmt.Sprintf("  %s %s - %s: %v > %v", qr.query.hostname,
+				qr.query.start.Format(timeLayout), qr.query.end.Format(timeLayout),
+				f.format(qr.queryDuration), f.format(qr.query.expected))))
+		}
+	}
+
+	if summary.retries > 0 {
+		fmt.Fprintf(w, "Retries after transient errors: %d\n", summary.retries)
+	}
+	if summary.noData > 0 {
+		fmt.Fprintf(w, "Queries with no data: %d (%.1f%%)\n", summary.noData, summary.noDataPercentage())
+	}
+	if summary.warmups > 0 {
+		fmt.Fprintf(w, "Warmup queries excluded: %d\n", summary.warmups)
+	}
+	if summary.planWarmups > 0 {
+		fmt.Fprintf(w, "Plan cache warmup queries: %d\n", summary.planWarmups)
+	}
+	if summary.backpressure.blocked > 0 {
+		fmt.Fprintf(w, "Workers blocked sending results: %d times, %v total\n",
+			summary.backpressure.blocked, f.format(summary.backpressure.blockedTime))
+	}
+	if summary.errors > 0 {
+		fmt.Fprintln(w, p.red(fmt.Sprintf("Failed queries: %d (first error: %v)", summary.errors, summary.firstError)))
+	}
+	if summary.timedOut > 0 {
+		fmt.Fprintln(w, p.yellow(fmt.Sprintf("Timed out queries: %d", summary.timedOut)))
+	}
+	if summary.skipped > 0 {
+		fmt.Fprintln(w, p.yellow(fmt.Sprintf("Skipped queries: %d (stalled hosts: %s)", summary.skipped, strings.Join(summary.skippedHosts, ", "))))
+	}
+}
+
+// printOverMaxCPU writes the number of results in summary with a maximum CPU
+// usage above maxCPU, and each of those results, to w.
+func printOverMaxCPU(w io.Writer, summary querySummary, maxCPU float64, p palette) {
+	line := fmt.Sprintf("Queries with max CPU usage above %v: %d", maxCPU, len(summary.overMaxCPU))
+	if len(summary.overMaxCPU) == 0 {
+		fmt.Fprintln(w, p.green(line))
+		return
+	}
+	fmt.Fprintln(w, p.red(line))
+	for _, qr := range summary.overMaxCPU {
+		fmt.Fprintln(w, p.red(fmt.Sprintf("  %s %s - %s: %v", qr.query.hostname,
+			qr.query.start.Format(timeLayout), qr.query.end.Format(timeLayout), qr.maxCPU)))
+	}
+}
+
+// printSlowest writes the slowest queries of summary to w, slowest first,
+// with their durations formatted by f.
+func printSlowest(w io.Writer, summary querySummary, f durationFormat) {
+	fmt.Fprintf(w, "Slowest queries: %d\n", len(summary.slowest))
+	for _, qr := range summary.slowest {
+		fmt.Fprintf(w, "  %s %s - %s: %v\n", qr.query.hostname,
+			qr.query.start.Format(timeLayout), qr.query.end.Format(timeLayout), f.format(qr.queryDuration))
+	}
+}
+
+// printWorkers writes a table of the queries executed by each worker in
+// summary to w, to show any imbalance between workers. Durations are
+// formatted by f, rounded by default.
+func printWorkers(w io.Writer, summary querySummary, f durationFormat) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Worker\tQueries\tTotal\tMin\tMax\tMean\t")
+	for _, ws := range summary.workers {
+		fmt.Fprintf(tw, "%d\t%d\t%v\t%v\t%v\t%v\t\n", ws.id, ws.count, f.formatRounded(ws.sum),
+			f.formatRounded(ws.min), f.formatRounded(ws.max), f.formatRounded(ws.mean()))
+	}
+	tw.Flush()
+}
+
+// printCompact writes summary to w as a single line, such as:
+//
+//	100 queries in 1.2s (mean 12ms, p99 45ms, 83 q/s)
+//
+// Durations are formatted by f. By default they are rounded to the
+// millisecond, or microsecond if shorter.
+func printCompact(w io.Writer, summary querySummary, f durationFormat) {
+	fmt.Fprintf(w, "%d queries in %v (mean %v, p99 %v, %.0f q/s)\n", summary.count,
+		f.formatRounded(summary.elapsed), f.formatRounded(summary.mean), f.formatRounded(summary.p99), summary.qps())
+}
+
+// printSummaryLine writes summary to w as a single line of key=value pairs
+// for scripts, such as:
+//
+//	RESULT count=100 errors=0 mean_us=12000 median_us=11000 p95_us=30000 p99_us=45000 min_us=900 max_us=52000 qps=83.0
+//
+// The keys are stable and durations are integer microseconds.
+func printSummaryLine(w io.Writer, summary querySummary) {
+	us := func(d time.Duration) int64 { return d.Microseconds() }
+	fmt.Fprintf(w, "RESULT count=%d errors=%d mean_us=%d median_us=%d p95_us=%d p99_us=%d min_us=%d max_us=%d qps=%.1f\n",
+		summary.count, summary.errors, us(summary.mean), us(summary.median), us(summary.p95), us(summary.p99),
+		us(summary.min), us(summary.max), summary.qps())
+}
+
+// summaryField is a metric of a querySummary that can be selected by name
+// for output.
+type summaryField struct {
+	name  string
+	value func(querySummary) interface{}
+}
+
+// summaryFields are the metrics that can be selected for output, in the
+// order they are output.
+var summaryFields = []summaryField{
+	{"count", func(s querySummary) interface{} { return s.count }},
+	{"sum", func(s querySummary) interface{} { return s.sum }},
+	{"min", func(s querySummary) interface{} { return s.min }},
+	{"max", func(s querySummary) interface{} { return s.max }},
+	{"mean", func(s querySummary) interface{} { return s.mean }},
+	{"geomean", func(s querySummary) interface{} { return s.geomean }},
+	{"stddev", func(s querySummary) interface{} { return s.stddev }},
+	{"median", func(s querySummary) interface{} { return s.median }},
+	{"p95", func(s querySummary) interface{} { return s.p95 }},
+	{"p99", func(s querySummary) interface{} { return s.p99 }},
+	{"elapsed", func(s querySummary) interface{} { return s.elapsed }},
+	{"qps", func(s querySummary) interface{} { return s.qps() }},
+	{"throughput", func(s querySummary) interface{} { return s.throughput }},
+}
+
+// validateFields returns an error if any of fields is not the name of one
+// of the summaryFields.
+func validateFields(fields []string) error {
+	for _, f := range fields {
+		if !isSummaryField(f) {
+			names := make([]string, len(summaryFields))
+			for i, sf := range summaryFields {
+				names[i] = sf.name
+			}
+			return fmt.Errorf("unknown field %q. must be one of: %s", f, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+func isSummaryField(name string) bool {
+	for _, sf := range summaryFields {
+		if sf.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// printFields writes the summary fields named in fields to w, one per line
+// as "name: value", in the order of summaryFields. Durations are formatted
+// by f.
+func printFields(w io.Writer, summary querySummary, fields []string, f durationFormat) {
+	selected := map[string]bool{}
+	for _, f := range fields {
+		selected[f] = true
+	}
+	for _, sf := range summaryFields {
+		if !selected[sf.name] {
+			continue
+		}
+		switch v := sf.value(summary).(type) {
+		case time.Duration:
+			fmt.Fprintf(w, "%s: %v\n", sf.name, f.format(v))
+		case float64:
+			fmt.Fprintf(w, "%s: %.2f\n", sf.name, v)
+		default:
+			fmt.Fprintf(w, "%s: %v\n", sf.name, v)
+		}
+	}
+}
+
+// roundDuration rounds d to the millisecond, or to the microsecond if d is
+// less than a millisecond, for concise display.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintCompact(t *testing.T) {
+	summary := querySummary{
+		count:   100,
+		mean:    12345 * time.Microsecond,
+		p99:     45 * time.Millisecond,
+		elapsed: 1200 * time.Millisecond,
+	}
+	var buf bytes.Buffer
+	printCompact(&buf, summary, durationFormat{})
+	require.Equal(t, "100 queries in 1.2s (mean 12ms, p99 45ms, 83 q/s)\n", buf.String())
+
+	buf.Reset()
+	printCompact(&buf, querySummary{}, durationFormat{})
+	require.Equal(t, "0 queries in 0s (mean 0s, p99 0s, 0 q/s)\n", buf.String())
+
+	buf.Reset()
+	printCompact(&buf, summary, newDurationFormat("ms", 1))
+	require.Equal(t, "100 queries in 1200.0ms (mean 12.3ms, p99 45.0ms, 83 q/s)\n", buf.String())
+}
+
+func TestPrintFields(t *testing.T) {
+	summary := querySummary{
+		count:   100,
+		sum:     2 * time.Second,
+		mean:    20 * time.Millisecond,
+		p99:     45 * time.Millisecond,
+		elapsed: 1250 * time.Millisecond,
+	}
+	var buf bytes.Buffer
+	printFields(&buf, summary, []string{"qps", "count", "p99"}, durationFormat{})
+	require.Equal(t, "count: 100\np99: 45ms\nqps: 80.00\n", buf.String())
+
+	require.NoError(t, validateFields([]string{"count", "p99", "qps"}))
+	require.NoError(t, validateFields([]string{"p95"}))
+	require.Error(t, validateFields([]string{"count", "p90"}))
+}
+
+func TestPrintSummaryLine(t *testing.T) {
+	summary := querySummary{
+		count:   200,
+		errors:  1,
+		min:     50 * time.Microsecond,
+		max:     900 * time.Microsecond,
+		mean:    123456 * time.Nanosecond,
+		median:  110 * time.Microsecond,
+		p95:     300 * time.Microsecond,
+		p99:     450 * time.Microsecond,
+		elapsed: 2 * time.Second,
+	}
+	var buf bytes.Buffer
+	printSummaryLine(&buf, summary)
+	require.Equal(t, "RESULT count=200 errors=1 mean_us=123 median_us=110 p95_us=300 p99_us=450 min_us=50 max_us=900 qps=100.0\n", buf.String())
+}
+
+func TestDurationFormat(t *testing.T) {
+	d := 12345678 * time.Nanosecond
+	require.Equal(t, "12.345ms", durationFormat{}.format(d))
+	require.Equal(t, "12.346ms", newDurationFormat("ms", 3).format(d))
+	require.Equal(t, "12ms", newDurationFormat("ms", 0).format(d))
+	require.Equal(t, "12345.7µs", newDurationFormat("us", 1).format(d))
+	require.Equal(t, "12345678ns", newDurationFormat("ns", 0).format(d))
+	require.Equal(t, durationFormat{}, newDurationFormat("auto", 3))
+
+	summary := querySummary{count: 1, mean: d, p99: 2 * d}
+	var buf bytes.Buffer
+	printFields(&buf, summary, []string{"mean", "p99"}, newDurationFormat("ms", 2))
+	require.Equal(t, "mean: 12.35ms\np99: 24.69ms\n", buf.String())
+}
+
+func TestPrintSummaryColor(t *testing.T) {
+	slow := good1Query
+	slow.expected = time.Millisecond
+	summary := querySummary{
+		count:       1,
+		sloChecked:  true,
+		sloBreaches: []queryResult{{query: slow, queryDuration: 2 * time.Millisecond}},
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, summary, durationFormat{}, palette{enabled: true})
+	require.Contains(t, buf.String(), "\x1b[31mSLO breaches: 1\x1b[0m\n")
+
+	buf.Reset()
+	printSummary(&buf, summary, durationFormat{}, newPalette("never", os.Stdout))
+	require.NotContains(t, buf.String(), "\x1b")
+	require.Contains(t, buf.String(), "SLO breaches: 1\n")
+
+	buf.Reset()
+	printSummary(&buf, summary, newDurationFormat("us", 0), palette{})
+	require.Contains(t, buf.String(), "host_000008 2017-01-01 08:59:22 - 2017-01-01 09:59:22: 2000µs > 1000µs\n")
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	require.NoError(t, err)
+	defer f.Close()
+	buf.Reset()
+	printSummary(&buf, summary, durationFormat{}, newPalette("auto", f))
+	require.NotContains(t, buf.String(), "\x1b")
+
+	require.True(t, newPalette("always", f).enabled)
+}
+
+func TestPrintWorkers(t *testing.T) {
+	results := []queryResult{
+		{query: good1Query, worker: 1, queryDuration: 2 * time.Millisecond},
+		{query: good2Query, worker: 0, queryDuration: 1 * time.Millisecond},
+		{query: good1Query, worker: 1, queryDuration: 4 * time.Millisecond},
+	}
+	summary, err := summariseWith(&CLI{PerWorker: true}, results...)
+	require.NoError(t, err)
+	require.Equal(t, []workerSummary{
+		{id: 0, count: 1, sum: time.Millisecond, min: time.Millisecond, max: time.Millisecond},
+		{id: 1, count: 2, sum: 6 * time.Millisecond, min: 2 * time.Millisecond, max: 4 * time.Millisecond},
+	}, summary.workers)
+
+	var buf bytes.Buffer
+	printWorkers(&buf, summary, durationFormat{})
+	want := "  Worker  Queries  Total  Min  Max  Mean\n" +
+		"       0        1    1ms  1ms  1ms   1ms\n" +
+		"       1        2    6ms  2ms  4ms   3ms\n"
+	require.Equal(t, want, buf.String())
+
+	buf.Reset()
+	printWorkers(&buf, summary, newDurationFormat("us", 0))
+	want = "  Worker  Queries   Total     Min     Max    Mean\n" +
+		"       0        1  1000µs  1000µs  1000µs  1000µs\n" +
+		"       1        2  6000µs  2000µs  4000µs  3000µs\n"
+	require.Equal(t, want, buf.String())
+
+	summary, err = summarise(results...)
+	require.NoError(t, err)
+	require.Empty(t, summary.workers)
+}
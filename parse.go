@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// csvBatchRows is the number of rows of the input CSV file read and
+	// parsed together as a batch.
+	csvBatchRows = 256
+	// csvReadAhead is the number of batches read ahead of the queries sent.
+	csvReadAhead = 8
+)
+
+// csvParsers is the number of goroutines parsing batches of rows into
+// queries. It is a variable so benchmarks can compare different numbers.
+var csvParsers = 2
+
+// rowBatch is a batch of consecutive rows of the input CSV file. line is the
+// number of its first row, counting the rows after the header from 1. err is
+// the error reading the row after the last row of the batch, if any, which
+// ends the input. If bad rows are skipped, skipErr is instead the error
+// reading a malformed row after the last row, which ends only the batch.
+// The parsed queries of the batch are sent on parsed.
+type rowBatch struct {
+	line    int
+	rows    [][]string
+	err     error
+	skipErr error
+	parsed  chan parsedBatch
+}
+
+// parsedBatch holds the queries parsed from a rowBatch, up to the first row
+// that failed to parse, and the error of that row or of reading the batch.
+// If bad rows are skipped, it holds the queries of all the valid rows, and
+// skipped holds the errors of the others.
+type parsedBatch struct {
+	queries []query
+	err     error
+	skipped []error
+}
+
+// The fields of an input row identified by a parseError.
+const (
+	fieldHostname = "hostname"
+	fieldStart    = "start time"
+	fieldEnd      = "end time"
+	fieldExpected = "expected duration"
+)
+
+// errUnmappedHostname is the error of a parseError for a hostname that is
+// not in the strict hostname map.
+var errUnmappedHostname = errors.New("not in the hostname map")
+
+// parseError is an error parsing the field of an input row into a query,
+// identifying the field, such as fieldStart, and its value. An empty value
+// is an error in itself, and err is nil.
+type parseError struct {
+	field string
+	value string
+	err   error
+}
+
+func (e *parseError) Error() string {
+	if e.err == nil {
+		return "empty " + e.field
+	}
+	return fmt.Sprintf("invalid %s: %s: %v", e.field, e.value, e.err)
+}
+
+func (e *parseError) Unwrap() error { return e.err }
+
+// parseCSV reads the rows of r after the header and sends the queries parsed
+// from them to the output channel, as described for readCSV. The rows are
+// read ahead in batches by one goroutine and the batches parsed by
+// csvParsers goroutines, while queries are sent in the order of the input
+// and the first invalid row ends the input with an error as if the rows
+// were read and parsed one at a time. All goroutines have finished when it
+// returns, so input can be rewound and read again.
+//
+// If config.SkipBadRows is set, invalid rows are instead logged to
+// config.skippedRows, if set, and skipped, and a badRowsError with the
+// number of them is returned once the whole input has been read.
+func parseCSV(ctx context.Context, config *CLI, cols csvColumns, r *csv.Reader, output chan<- query, max int) (int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	ordered := make(chan *rowBatch, csvReadAhead)
+	work := make(chan *rowBatch)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ordered)
+		defer close(work)
+		readBatches(ctx, r, config.SkipBadRows, ordered, work)
+	}()
+	for i := 0; i < csvParsers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range work {
+				b.parsed <- parseBatch(config, cols, b)
+			}
+		}()
+	}
+
+	sent, skipped := 0, 0
+	for b := range ordered {
+		var pb parsedBatch
+		select {
+		case <-ctx.Done():
+			return sent, nil
+		case pb = <-b.parsed:
+		}
+		for _, q := range pb.queries {
+			if max != 0 && sent == max {
+				return sent, nil
+			}
+			if !sendQuery(ctx, q, output) {
+				return sent, nil
+			}
+			sent++
+		}
+		if pb.err != nil {
+			return sent, pb.err
+		}
+		for _, err := range pb.skipped {
+			if config.skippedRows != nil {
+				fmt.Fprintf(config.skippedRows, "skipping bad row: %v\n", err)
+			}
+			skipped++
+		}
+	}
+	return sent, skippedRowsError(skipped)
+}
+
+// badRowsError is the error reading an input with bad rows that were
+// skipped.
+type badRowsError struct {
+	count int
+}
+
+func (e *badRowsError) Error() string {
+	return fmt.Sprintf("skipped %d bad input rows", e.count)
+}
+
+// skippedRowsError returns a badRowsError for count skipped rows, or nil if
+// no rows were skipped.
+func skippedRowsError(count int) error {
+	if count == 0 {
+		return nil
+	}
+	return &badRowsError{count: count}
+}
+
+// readBatches reads the rows of r in batches, sending each batch on ordered
+// and then work, until the input ends or ctx is done. If skip is set, a
+// malformed row ends the batch with it as skipErr and reading continues.
+func readBatches(ctx context.Context, r *csv.Reader, skip bool, ordered, work chan<- *rowBatch) {
+	line := 1
+	for {
+		b := &rowBatch{line: line, parsed: make(chan parsedBatch, 1)}
+		for len(b.rows) < csvBatchRows {
+			row, err := r.Read()
+			var perr *csv.ParseError
+			if skip && errors.As(err, &perr) {
+				b.skipErr = err
+				break
+			}
+			if err != nil {
+				if err != io.EOF {
+					b.err = err
+				}
+				break
+			}
+			b.rows = append(b.rows, row)
+		}
+		line += len(b.rows)
+		if b.skipErr != nil {
+			line++
+		}
+		last := len(b.rows) < csvBatchRows && b.skipErr == nil
+		if len(b.rows) == 0 && b.err == nil && b.skipErr == nil {
+			return
+		}
+		for _, ch := range []chan<- *rowBatch{ordered, work} {
+			select {
+			case <-ctx.Done():
+				return
+			case ch <- b:
+			}
+		}
+		if last {
+			return
+		}
+	}
+}
+
+// parseBatch parses the rows of b into queries, stopping at the first row
+// that fails to parse unless config.SkipBadRows is set.
+func parseBatch(config *CLI, cols csvColumns, b *rowBatch) parsedBatch {
+	queries := make([]query, 0, len(b.rows))
+	var skipped []error
+	for i, row := range b.rows {
+		q, err := newQuery(config, cols, row)
+		if err != nil {
+			err = fmt.Errorf("line %d: %w", b.line+i, err)
+			if !config.SkipBadRows {
+				return parsedBatch{queries: queries, err: err}
+			}
+			skipped = append(skipped, err)
+			continue
+		}
+		queries = append(queries, q)
+	}
+	if b.skipErr != nil {
+		skipped = append(skipped, b.skipErr)
+	}
+	return parsedBatch{queries: queries, err: b.err, skipped: skipped}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// csvInput returns an input CSV file of n queries for distinct hosts.
+func csvInput(n int) string {
+	var sb strings.Builder
+	sb.WriteString(goodHeader)
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "host_%06d,%s,%s\n", i, start.Format(timeLayout), start.Add(time.Hour).Format(timeLayout))
+	}
+	return sb.String()
+}
+
+// readAll reads the queries of input with readCSV, returning them in the
+// order they were sent.
+func readAll(input string, max int) ([]query, error) {
+	output := make(chan query)
+	var err error
+	go func() {
+		_, err = readCSV(context.Background(), &CLI{}, strings.NewReader(input), output, max)
+		close(output)
+	}()
+	queries := collect(output)
+	return queries, err
+}
+
+func TestReadCSVBatches(t *testing.T) {
+	n := 3*csvBatchRows + 10
+	queries, err := readAll(csvInput(n), 0)
+	require.NoError(t, err)
+	require.Len(t, queries, n)
+	for i, q := range queries {
+		require.Equal(t, fmt.Sprintf("host_%06d", i), q.hostname)
+	}
+
+	queries, err = readAll(csvInput(n), csvBatchRows+1)
+	require.NoError(t, err)
+	require.Len(t, queries, csvBatchRows+1)
+
+	// The rows before the first invalid row are sent, in a later batch.
+	bad := 2*csvBatchRows + 5
+	lines := strings.SplitAfter(csvInput(n), "\n")
+	lines[bad] = "host_bad,yesterday,2017-01-01 01:00:00\n"
+	lines[bad+1] = "host_bad,2017-01-01 00:00:00,tomorrow\n"
+	queries, err = readAll(strings.Join(lines, ""), 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), fmt.Sprintf("line %d: invalid start time: yesterday", bad))
+	require.Len(t, queries, bad-1)
+}
+
+func TestReadCSVSkipBadRows(t *testing.T) {
+	n := 2*csvBatchRows + 10
+	lines := strings.SplitAfter(csvInput(n), "\n")
+	lines[3] = "host_bad,yesterday,2017-01-01 01:00:00\n"
+	lines[csvBatchRows+1] = "host\"bad,2017-01-01 00:00:00,2017-01-01 01:00:00\n"
+	lines[csvBatchRows+2] = "host_bad,2017-01-01 00:00:00\n"
+	var log bytes.Buffer
+	config := &CLI{SkipBadRows: true, skippedRows: &log}
+	output := make(chan query)
+	var sent int
+	var err error
+	go func() {
+		sent, err = readCSV(context.Background(), config, strings.NewReader(strings.Join(lines, "")), output, 0)
+		close(output)
+	}()
+	queries := collect(output)
+	require.EqualError(t, err, "skipped 3 bad input rows")
+	require.Len(t, queries, n-3)
+	require.Equal(t, n-3, sent)
+	require.Equal(t, "host_000000", queries[0].hostname)
+	require.Equal(t, "host_000003", queries[2].hostname)
+	require.Equal(t, fmt.Sprintf("host_%06d", n-1), queries[n-4].hostname)
+
+	logged := strings.Split(strings.TrimSpace(log.String()), "\n")
+	require.Len(t, logged, 3)
+	require.Contains(t, logged[0], "line 3: invalid start time: yesterday")
+	require.Contains(t, logged[1], "bare \" in non-quoted-field")
+	require.Contains(t, logged[2], fmt.Sprintf("record on line %d: wrong number of fields", csvBatchRows+3))
+
+	summary, err := runPipeline(context.Background(), &CLI{Workers: 2, SkipBadRows: true}, []io.Reader{strings.NewReader(strings.Join(lines, ""))}, &fakeExecutor{})
+	require.NoError(t, err)
+	require.Equal(t, n-3, summary.count)
+	require.Equal(t, 3, summary.badRows)
+}
+
+func BenchmarkReadCSV(b *testing.B) {
+	input := csvInput(100000)
+	for _, parsers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("parsers=%d", parsers), func(b *testing.B) {
+			defer func(n int) { csvParsers = n }(csvParsers)
+			csvParsers = parsers
+			for i := 0; i < b.N; i++ {
+				if _, err := readAll(input, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadQueriesParseError(t *testing.T) {
+	tests := []struct {
+		row   string
+		field string
+		value string
+	}{
+		{badHostname, fieldHostname, ""},
+		{badStartTime, fieldStart, "08:59:22 2017-01-01"},
+		{badEndTime, fieldEnd, "2017-21-01 09:59:22"},
+		{"host_000001,2017-01-02 14:02:02,2017-01-02 13:02:02\n", fieldEnd, "2017-01-02 13:02:02"},
+	}
+	for _, tt := range tests {
+		_, err := parse(goodHeader + good1 + tt.row)
+		var pe *parseError
+		require.True(t, errors.As(err, &pe), tt.row)
+		require.Equal(t, tt.field, pe.field, tt.row)
+		require.Equal(t, tt.value, pe.value, tt.row)
+		require.True(t, strings.HasPrefix(err.Error(), "line 2: "), err.Error())
+	}
+
+	_, err := parse(goodHeader + badEndTime)
+	require.Contains(t, err.Error(), "invalid end time: 2017-21-01 09:59:22: ")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// requiredColumns returns the names of the columns used by the benchmark
+// query: the time, host and value columns.
+func (n schemaNames) requiredColumns() []string {
+	return []string{defaultName(n.ts, "ts"), defaultName(n.host, "host"), defaultName(n.value, "usage")}
+}
+
+// tableName returns the name of the table queried by the benchmark query.
+func (n schemaNames) tableName() string {
+	return defaultName(n.table, "cpu_usage")
+}
+
+// defaultName returns name, or def if name is empty.
+func defaultName(name, def string) string {
+	if name == "" {
+		return def
+	}
+	return name
+}
+
+// checkTable returns an error if the columns of the table named by names
+// are not all of its requiredColumns. No columns means there is no table.
+func checkTable(names schemaNames, columns []string) error {
+	if len(columns) == 0 {
+		return fmt.Errorf("table %s does not exist", names.tableName())
+	}
+	have := map[string]bool{}
+	for _, c := range columns {
+		have[c] = true
+	}
+	var missing []string
+	for _, c := range names.requiredColumns() {
+		if !have[c] {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// probeDB is the database access used by probe beyond executing queries.
+type probeDB interface {
+	// ping checks the database can be reached.
+	ping(ctx context.Context) error
+	// tableColumns returns the names of the columns of table, given as
+	// SQL by quoteTable, or no columns if the table does not exist.
+	tableColumns(ctx context.Context, table string) ([]string, error)
+	// sampleQuery returns a query for data that exists in the benchmark
+	// table.
+	sampleQuery(ctx context.Context) (query, error)
+}
+
+// probe checks that the benchmark can be run against a database. It pings
+// the database, checks the table named by names has the required columns and runs
+// a sample query with the executor returned by prepare, writing the outcome
+// and timing of each step to w. It returns the error of the first step to
+// fail.
+func probe(ctx context.Context, w io.Writer, db probeDB, names schemaNames, prepare func() (queryExecutor, error)) error {
+	start := time.Now()
+	if err := db.ping(ctx); err != nil {
+		fmt.Fprintf(w, "Ping: FAILED: %v\n", err)
+		return err
+	}
+	fmt.Fprintf(w, "Ping: ok (%v)\n", time.Since(start).Truncate(time.Microsecond))
+
+	start = time.Now()
+	columns, err := db.tableColumns(ctx, quoteTable(names.table, "cpu_usage"))
+	if err == nil {
+		err = checkTable(names, columns)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "Schema: FAILED: %v\n", err)
+		return err
+	}
+	fmt.Fprintf(w, "Schema: ok (%v)\n", time.Since(start).Truncate(time.Microsecond))
+
+	exec, err := prepare()
+	if err != nil {
+		fmt.Fprintf(w, "Prepare: FAILED: %v\n", err)
+		return err
+	}
+	q, err := db.sampleQuery(ctx)
+	if err == nil {
+		var qr queryResult
+		qr, err = exec.executeQuery(ctx, q)
+		if err == nil {
+			fmt.Fprintf(w, "Sample query: ok (%s %s - %s: %v)\n", q.hostname,
+				q.start.Format(timeLayout), q.end.Format(timeLayout), qr.queryDuration.Truncate(time.Microsecond))
+			return nil
+		}
+	}
+	fmt.Fprintf(w, "Sample query: FAILED: %v\n", err)
+	return err
+}
+
+// sqlProbeDB is a probeDB for a real database with the benchmark table and
+// columns named by names.
+type sqlProbeDB struct {
+	db    *sql.DB
+	names schemaNames
+}
+
+func (p sqlProbeDB) ping(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+func (p sqlProbeDB) tableColumns(ctx context.Context, table string) ([]string, error) {
+	// to_regclass resolves the table as the benchmark query does, with
+	// any schema name and the search path, and is NULL if there is none.
+	sqlQ := "SELECT attname FROM pg_attribute WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped"
+	rows, err := p.db.QueryContext(ctx, sqlQ, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var c string
+		if err := rows.Scan(&c); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// sampleQuery returns a query for the hour up to the most recent row in the
+// benchmark table.
+func (p sqlProbeDB) sampleQuery(ctx context.Context) (query, error) {
+	q := query{}
+	host, ts := quoteIdentifier(p.names.host, "host"), quoteIdentifier(p.names.ts, "ts")
+	sqlQ := fmt.Sprintf("SELECT %s, %s FROM %s ORDER BY %s DESC LIMIT 1", host, ts, quoteTable(p.names.table, "cpu_usage"), ts)
+	row := p.db.QueryRowContext(ctx, sqlQ)
+	if err := row.Scan(&q.hostname, &q.end); err != nil {
+		return query{}, err
+	}
+	q.end = q.end.UTC()
+	q.start = q.end.Add(-time.Hour)
+	return q, nil
+}
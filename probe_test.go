@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProbeDB is a probeDB that does not use a database.
+type fakeProbeDB struct {
+	pingErr error
+	columns []string
+}
+
+func (p fakeProbeDB) ping(ctx context.Context) error {
+	return p.pingErr
+}
+
+func (p fakeProbeDB) tableColumns(ctx context.Context, table string) ([]string, error) {
+	return p.columns, nil
+}
+
+func (p fakeProbeDB) sampleQuery(ctx context.Context) (query, error) {
+	return good1Query, nil
+}
+
+func TestProbe(t *testing.T) {
+	ctx := context.Background()
+	db := fakeProbeDB{columns: []string{"ts", "host", "usage"}}
+	exec := &fakeExecutor{}
+	prepare := func() (queryExecutor, error) { return exec, nil }
+	var buf bytes.Buffer
+	require.NoError(t, probe(ctx, &buf, db, schemaNames{}, prepare))
+	require.Regexp(t, regexp.MustCompile(`^Ping: ok \(.*\)
+Schema: ok \(.*\)
+Sample query: ok \(host_000008 2017-01-01 08:59:22 - 2017-01-01 09:59:22: .*\)
+$`), buf.String())
+	require.Equal(t, []query{good1Query}, exec.executed)
+
+	buf.Reset()
+	db.columns = []string{"ts", "hostname", "usage"}
+	require.EqualError(t, probe(ctx, &buf, db, schemaNames{}, prepare), "missing columns: host")
+	require.Contains(t, buf.String(), "Schema: FAILED: missing columns: host\n")
+
+	buf.Reset()
+	db.columns = nil
+	require.EqualError(t, probe(ctx, &buf, db, schemaNames{}, prepare), "table cpu_usage does not exist")
+
+	buf.Reset()
+	db.columns = schemaNames{}.requiredColumns()
+	badPrepare := func() (queryExecutor, error) { return nil, errors.New("no such table") }
+	require.Error(t, probe(ctx, &buf, db, schemaNames{}, badPrepare))
+	require.Contains(t, buf.String(), "Prepare: FAILED: no such table\n")
+
+	buf.Reset()
+	db.pingErr = errors.New("connection refused")
+	require.Error(t, probe(ctx, &buf, db, schemaNames{}, prepare))
+	require.Equal(t, "Ping: FAILED: connection refused\n", buf.String())
+}
+
+func TestProbeSchemaNames(t *testing.T) {
+	ctx := context.Background()
+	names := schemaNames{table: "metrics.cpu", value: "value", host: "hostname", ts: "time"}
+	db := fakeProbeDB{columns: []string{"time", "hostname", "usage"}}
+	prepare := func() (queryExecutor, error) { return &fakeExecutor{}, nil }
+	var buf bytes.Buffer
+	require.EqualError(t, probe(ctx, &buf, db, names, prepare), "missing columns: value")
+
+	db.columns = nil
+	require.EqualError(t, probe(ctx, &buf, db, names, prepare), "table metrics.cpu does not exist")
+
+	db.columns = []string{"time", "hostname", "value"}
+	require.NoError(t, probe(ctx, &buf, db, names, prepare))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiles starts writing a CPU profile to config.CPUProfile, if set.
+// It returns a function that stops the CPU profile and writes a heap profile
+// to config.MemProfile, if set, which must be called at the end of the run
+// whether or not it succeeded.
+func startProfiles(config *CLI) (stop func() error, err error) {
+	var cpu *os.File
+	if config.CPUProfile != "" {
+		if cpu, err = os.Create(config.CPUProfile); err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	return func() error {
+		var err error
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			err = cpu.Close()
+		}
+		if config.MemProfile != "" {
+			if merr := writeHeapProfile(config.MemProfile); err == nil {
+				err = merr
+			}
+		}
+		return err
+	}, nil
+}
+
+// writeHeapProfile writes a profile of the live heap to filename.
+func writeHeapProfile(filename string) (err error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	// Collect garbage so the profile is of the memory still in use.
+	runtime.GC()
+	return pprof.WriteHeapProfile(f)
+}
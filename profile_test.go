@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartProfiles(t *testing.T) {
+	dir := t.TempDir()
+	config := &CLI{
+		CPUProfile: filepath.Join(dir, "cpu.prof"),
+		MemProfile: filepath.Join(dir, "mem.prof"),
+	}
+	stop, err := startProfiles(config)
+	require.NoError(t, err)
+	require.NoError(t, stop())
+	for _, filename := range []string{config.CPUProfile, config.MemProfile} {
+		fi, err := os.Stat(filename)
+		require.NoError(t, err)
+		require.NotZero(t, fi.Size(), filename)
+	}
+
+	stop, err = startProfiles(&CLI{})
+	require.NoError(t, err)
+	require.NoError(t, stop())
+
+	_, err = startProfiles(&CLI{CPUProfile: filepath.Join(dir, "missing", "cpu.prof")})
+	require.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jackc/pgconn"
+)
+
+// reconnectWithPassword pings db and, if the server rejects the connection
+// for want of a password, closes db and connects again with the password
+// returned by prompt, as psql does. Otherwise it returns db, leaving any
+// other connection error to be reported when db is first used.
+func reconnectWithPassword(config *CLI, db *sql.DB, prompt func() (string, error)) (*sql.DB, error) {
+	if !shouldPromptPassword(config, pingDB(db, config.ConnectTimeout)) {
+		return db, nil
+	}
+	db.Close()
+	password, err := prompt()
+	if err != nil {
+		return nil, &connectError{err}
+	}
+	config.Password = password
+	return dbconnect(config)
+}
+
+// shouldPromptPassword returns true if err, the error of connecting with
+// config, is a failed password authentication and config gives no password
+// that could be wrong: no --password, --password-file or pgpass entry. A
+// --db-url is used as is, so there is no prompt for it.
+func shouldPromptPassword(config *CLI, err error) bool {
+	var pgErr *pgconn.PgError
+	if config.DBUrl != "" || !errors.As(err, &pgErr) || pgErr.Code != "28P01" {
+		return false
+	}
+	password, err := resolvePassword(config)
+	return err == nil && password == ""
+}
+
+// promptPassword writes prompt to w and reads a password from the terminal
+// tty, with echo turned off by stty while it is typed.
+func promptPassword(tty *os.File, w io.Writer, prompt string) (string, error) {
+	fmt.Fprint(w, prompt)
+	if err := stty(tty, "-echo"); err != nil {
+		return "", fmt.Errorf("cannot turn off terminal echo: %w", err)
+	}
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	fmt.Fprintln(w)
+	if serr := stty(tty, "echo"); err == nil && serr != nil {
+		return "", fmt.Errorf("cannot turn on terminal echo: %w", serr)
+	}
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("cannot read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stty runs stty with args on the terminal tty.
+func stty(tty *os.File, args ...string) error {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	return cmd.Run()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// summaryQuantiles estimates the quantiles of query durations reported in a
+// summary.
+type summaryQuantiles struct {
+	p50, p95q, p99q *p2Quantile
+}
+
+func newSummaryQuantiles() *summaryQuantiles {
+	return &summaryQuantiles{p50: newP2Quantile(0.5), p95q: newP2Quantile(0.95), p99q: newP2Quantile(0.99)}
+}
+
+func (s *summaryQuantiles) add(d time.Duration) {
+	s.p50.add(float64(d))
+	s.p95q.add(float64(d))
+	s.p99q.add(float64(d))
+}
+
+func (s *summaryQuantiles) median() time.Duration { return time.Duration(math.Round(s.p50.value())) }
+func (s *summaryQuantiles) p95() time.Duration    { return time.Duration(math.Round(s.p95q.value())) }
+func (s *summaryQuantiles) p99() time.Duration    { return time.Duration(math.Round(s.p99q.value())) }
+
+// p2Quantile estimates a quantile of a stream of values in constant memory
+// using the P² algorithm of Jain and Chlamtac: five markers track the
+// minimum, the quantile, the maximum and two points between, and their
+// heights are adjusted with a piecewise-parabolic fit as values arrive.
+type p2Quantile struct {
+	p     float64
+	count int
+	n     [5]int     // actual marker positions, from 1
+	np    [5]float64 // desired marker positions
+	dn    [5]float64 // increments of the desired positions
+	q     [5]float64 // marker heights
+}
+
+// newP2Quantile returns an estimator of the quantile p, from 0 to 1.
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{
+		p:  p,
+		dn: [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+// add adds x to the values the quantile is estimated from.
+func (e *p2Quantile) add(x float64) {
+	if e.count < 5 {
+		e.q[e.count] = x
+		e.count++
+		if e.count == 5 {
+			sort.Float64s(e.q[:])
+			for i := range e.n {
+				e.n[i] = i + 1
+			}
+			e.np = [5]float64{1, 1 + 2*e.p, 1 + 4*e.p, 3 + 2*e.p, 5}
+		}
+		return
+	}
+	e.count++
+
+	// Find the cell k with q[k] <= x < q[k+1], extending the extremes.
+	var k int
+	switch {
+	case x < e.q[0]:
+		e.q[0] = x
+		k = 0
+	case x >= e.q[4]:
+		e.q[4] = x
+		k = 3
+	default:
+		for k = 0; k < 3; k++ {
+			if x < e.q[k+1] {
+				break
+			}
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		e.n[i]++
+	}
+	for i := range e.np {
+		e.np[i] += e.dn[i]
+	}
+
+	// Adjust the heights of the middle markers if they are off their
+	// desired positions.
+	for i := 1; i <= 3; i++ {
+		d := e.np[i] - float64(e.n[i])
+		if (d >= 1 && e.n[i+1]-e.n[i] > 1) || (d <= -1 && e.n[i-1]-e.n[i] < -1) {
+			s := 1
+			if d < 0 {
+				s = -1
+			}
+			h := e.parabolic(i, float64(s))
+			if e.q[i-1] < h && h < e.q[i+1] {
+				e.q[i] = h
+			} else {
+				e.q[i] = e.q[i] + float64(s)*(e.q[i+s]-e.q[i])/float64(e.n[i+s]-e.n[i])
+			}
+			e.n[i] += s
+		}
+	}
+}
+
+// parabolic returns the piecewise-parabolic prediction of the height of
+// marker i moved by d.
+func (e *p2Quantile) parabolic(i int, d float64) float64 {
+	n0, n1, n2 := float64(e.n[i-1]), float64(e.n[i]), float64(e.n[i+1])
+	return e.q[i] + d/(n2-n0)*((n1-n0+d)*(e.q[i+1]-e.q[i])/(n2-n1)+(n2-n1-d)*(e.q[i]-e.q[i-1])/(n1-n0))
+}
+
+// value returns the estimate of the quantile. With fewer than five values,
+// it is the exact nearest-rank quantile.
+func (e *p2Quantile) value() float64 {
+	if e.count == 0 {
+		return 0
+	}
+	if e.count < 5 {
+		values := make([]float64, e.count)
+		copy(values, e.q[:e.count])
+		sort.Float64s(values)
+		rank := int(math.Ceil(e.p * float64(e.count)))
+		if rank < 1 {
+			rank = 1
+		}
+		return values[rank-1]
+	}
+	return e.q[2]
+}
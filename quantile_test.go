@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestP2Quantile(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	values := make([]float64, 10000)
+	estimators := map[float64]*p2Quantile{0.5: newP2Quantile(0.5), 0.95: newP2Quantile(0.95), 0.99: newP2Quantile(0.99)}
+	for i := range values {
+		// Exponentially distributed, like a latency long tail.
+		values[i] = rng.ExpFloat64() * 10
+		for _, e := range estimators {
+			e.add(values[i])
+		}
+	}
+	sort.Float64s(values)
+	for p, e := range estimators {
+		exact := values[int(math.Ceil(p*float64(len(values))))-1]
+		require.InEpsilon(t, exact, e.value(), 0.03, "p%v", p*100)
+	}
+}
+
+func TestP2QuantileFewValues(t *testing.T) {
+	e := newP2Quantile(0.5)
+	require.Equal(t, 0.0, e.value())
+	e.add(3)
+	e.add(1)
+	e.add(2)
+	require.Equal(t, 2.0, e.value())
+}
+
+func TestSummariseResultsApproxQuantiles(t *testing.T) {
+	var results []queryResult
+	for i := 1; i <= 1000; i++ {
+		results = append(results, queryResult{query: good1Query, queryDuration: time.Duration(i) * time.Microsecond})
+	}
+	summary, err := summariseWith(&CLI{ApproxQuantiles: true}, results...)
+	require.NoError(t, err)
+	require.True(t, summary.approx)
+	require.Equal(t, 0, summary.retained)
+	require.Equal(t, 1000, summary.count)
+	require.Equal(t, 500500*time.Microsecond, summary.sum)
+	require.InEpsilon(t, float64(500*time.Microsecond), float64(summary.median), 0.02)
+	require.InEpsilon(t, float64(950*time.Microsecond), float64(summary.p95), 0.02)
+	require.InEpsilon(t, float64(990*time.Microsecond), float64(summary.p99), 0.02)
+}
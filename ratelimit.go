@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+)
+
+// newRateLimiter returns a token bucket limiter for qps events per second
+// holding a single token, so waiting on it paces events at the rate,
+// without bursts to catch up after a pause.
+func newRateLimiter(qps float64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(qps), 1)
+}
+
+// limitRate sends the queries on the input channel to the output channel at
+// no more than qps queries per second.
+func limitRate(ctx context.Context, qps float64, input <-chan query, output chan<- query) {
+	defer close(output)
+
+	limiter := newRateLimiter(qps)
+	var q query
+	for recvQuery(ctx, &q, input) {
+		if limiter.Wait(ctx) != nil || !sendQuery(ctx, q, output) {
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := newRateLimiter(4)
+	var delays []time.Duration
+	for i := 0; i < 3; i++ {
+		delays = append(delays, limiter.ReserveN(now, 1).DelayFrom(now))
+	}
+	require.Equal(t, []time.Duration{0, 250 * time.Millisecond, 500 * time.Millisecond}, delays)
+
+	// A pause does not build up a burst.
+	now = now.Add(2 * time.Second)
+	require.Equal(t, time.Duration(0), limiter.ReserveN(now, 1).DelayFrom(now))
+	require.Equal(t, 250*time.Millisecond, limiter.ReserveN(now, 1).DelayFrom(now))
+}
+
+func TestRunPipelineRate(t *testing.T) {
+	input := goodHeader + strings.Repeat(good1, 5)
+	config := &CLI{Workers: 2, Rate: 100}
+	start := time.Now()
+	summary, err := runPipeline(context.Background(), config, []io.Reader{strings.NewReader(input)}, &fakeExecutor{})
+	require.NoError(t, err)
+	require.Equal(t, 5, summary.count)
+	require.Equal(t, 100.0, summary.requestedRate)
+	require.GreaterOrEqual(t, int64(time.Since(start)), int64(40*time.Millisecond))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// resultsHeader is the header row of the results CSV file.
+var resultsHeader = []string{"hostname", "start_time", "end_time", "min_cpu", "max_cpu", "duration_us"}
+
+// writeResults sends each query result on the input channel to the output
+// channel, writing the executed results as rows of CSV to w. Skipped and
+// warmup results are passed on but not written.
+//
+// Rows are buffered, so if flushInterval is non-zero the buffered rows are
+// flushed to w at that interval, so that a long run that crashes still has
+// the results up to the last flush. Otherwise rows are only flushed when the
+// buffer is full and once all results are written.
+func writeResults(ctx context.Context, w io.Writer, flushInterval time.Duration, input <-chan queryResult, output chan<- queryResult) error {
+	defer close(output)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(resultsHeader); err != nil {
+		return err
+	}
+
+	var tick <-chan time.Time
+	if flushInterval > 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			cw.Flush()
+			return cw.Error()
+		case <-tick:
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+		case qr, ok := <-input:
+			if !ok {
+				cw.Flush()
+				return cw.Error()
+			}
+			if qr.executed() {
+				if err := cw.Write(resultRow(qr)); err != nil {
+					return err
+				}
+			}
+			if !sendQueryResult(ctx, qr, output) {
+				cw.Flush()
+				return cw.Error()
+			}
+		}
+	}
+}
+
+// resultRow returns qr as a row of the results CSV file.
+func resultRow(qr queryResult) []string {
+	return []string{
+		qr.query.hostname,
+		qr.query.start.Format(timeLayout),
+		qr.query.end.Format(timeLayout),
+		strconv.FormatFloat(qr.minCPU, 'f', -1, 64),
+		strconv.FormatFloat(qr.maxCPU, 'f', -1, 64),
+		strconv.FormatInt(qr.queryDuration.Microseconds(), 10),
+	}
+}
+
+// writeDurations sends each query result on the input channel to the output
+// channel, writing the duration of the executed results to w in
+// microseconds, one per line, in the order they complete. Skipped and warmup
+// results are passed on but not written.
+func writeDurations(ctx context.Context, w io.Writer, input <-chan queryResult, output chan<- queryResult) error {
+	defer close(output)
+
+	bw := bufio.NewWriter(w)
+	var qr queryResult
+	for recvQueryResult(ctx, &qr, input) {
+		if qr.executed() {
+			if _, err := bw.WriteString(strconv.FormatInt(qr.queryDuration.Microseconds(), 10) + "\n"); err != nil {
+				return err
+			}
+		}
+		if !sendQueryResult(ctx, qr, output) {
+			break
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer that is safe to read while being written.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWriteResults(t *testing.T) {
+	input := make(chan queryResult)
+	output := make(chan queryResult)
+	var buf syncBuffer
+	errc := make(chan error)
+	go func() { errc <- writeResults(context.Background(), &buf, 0, input, output) }()
+
+	input <- queryResult{query: good1Query, minCPU: 1.5, maxCPU: 99, queryDuration: 1234 * time.Microsecond}
+	<-output
+	input <- queryResult{query: good2Query, skipped: true}
+	<-output
+	close(input)
+	_, ok := <-output
+	require.False(t, ok)
+	require.NoError(t, <-errc)
+
+	want := "hostname,start_time,end_time,min_cpu,max_cpu,duration_us\n" +
+		"host_000008,2017-01-01 08:59:22,2017-01-01 09:59:22,1.5,99,1234\n"
+	require.Equal(t, want, buf.String())
+}
+
+func TestWriteResultsFlushInterval(t *testing.T) {
+	input := make(chan queryResult)
+	output := make(chan queryResult)
+	var buf syncBuffer
+	errc := make(chan error)
+	go func() { errc <- writeResults(context.Background(), &buf, 5*time.Millisecond, input, output) }()
+
+	input <- queryResult{query: good1Query, queryDuration: time.Millisecond}
+	<-output
+	// The row is flushed by the interval before the input is closed.
+	require.Eventually(t, func() bool {
+		return bytes.Contains([]byte(buf.String()), []byte("host_000008"))
+	}, time.Second, time.Millisecond)
+
+	close(input)
+	<-output
+	require.NoError(t, <-errc)
+}
+
+func TestRunPipelineDumpDurations(t *testing.T) {
+	var buf syncBuffer
+	config := &CLI{Workers: 2, Iterations: 1, durations: &buf}
+	exec := &fakeExecutor{duration: 1500 * time.Microsecond}
+	input := goodHeader +
+		"host_000008,2017-01-01 08:59:22,2017-01-01 09:59:22\n" +
+		"host_000001,2017-01-02 13:02:02,2017-01-02 14:02:02\n" +
+		"host_000008,2017-01-02 18:50:28,2017-01-02 19:50:28\n"
+	summary, err := runPipeline(context.Background(), config, []io.Reader{strings.NewReader(input)}, exec)
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.count)
+	require.Equal(t, "1500\n1500\n1500\n", buf.String())
+}
+
+func TestRunPipelineOutputCSV(t *testing.T) {
+	var buf syncBuffer
+	config := &CLI{Workers: 2, Iterations: 1, resultsCSV: &buf}
+	exec := &fakeExecutor{duration: 1500 * time.Microsecond}
+	summary, err := runPipeline(context.Background(), config, []io.Reader{strings.NewReader(goodHeader + good1 + good2)}, exec)
+	require.NoError(t, err)
+	require.Equal(t, 2, summary.count)
+
+	// Results are written in the order they complete.
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Equal(t, strings.Join(resultsHeader, ","), lines[0])
+	require.ElementsMatch(t, []string{
+		"host_000008,2017-01-01 08:59:22,2017-01-01 09:59:22,0,0,1500",
+		"host_000001,2017-01-02 13:02:02,2017-01-02 14:02:02,0,0,1500",
+	}, lines[1:])
+}
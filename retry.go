@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// retryExecutor is a connExecutor that retries queries failing with a
+// transient error up to retries times, waiting backoff before the first
+// retry and doubling the wait for each further retry. If idempotentOnly is
+// set, queries are only retried if the benchmark SQL is known to be
+// read-only, so that a retry cannot repeat a write.
+//
+// The duration of a result is that of the successful attempt only; the
+// number of retries it took is recorded in the result.
+type retryExecutor struct {
+	connExecutor
+	retries int
+	backoff time.Duration
+	retry   bool
+	sleep   func(ctx context.Context, d time.Duration) error
+}
+
+// newRetryExecutor returns a retryExecutor for exec executing the SQL text.
+func newRetryExecutor(exec connExecutor, retries int, backoff time.Duration, idempotentOnly bool, text string) *retryExecutor {
+	return &retryExecutor{
+		connExecutor: exec,
+		retries:      retries,
+		backoff:      backoff,
+		retry:        !idempotentOnly || isReadOnlySQL(text),
+		sleep:        sleepContext,
+	}
+}
+
+func (e *retryExecutor) executeQuery(ctx context.Context, q query) (queryResult, error) {
+	wait := e.backoff
+	for attempt := 0; ; attempt++ {
+		qr, err := e.connExecutor.executeQuery(ctx, q)
+		if err == nil {
+			qr.retries = attempt
+			return qr, nil
+		}
+		if !e.retry || attempt >= e.retries || ctx.Err() != nil || !isTransient(err) {
+			return qr, err
+		}
+		if err := e.sleep(ctx, wait); err != nil {
+			return queryResult{}, err
+		}
+		wait *= 2
+	}
+}
+
+// sleepContext waits for d, returning early with the error of ctx if it is
+// done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// isTransient returns true if err is an error that may not recur if the
+// query is retried, such as a dropped connection or a timeout, rather than a
+// problem with the query itself.
+func isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	if pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions. The others are admin
+		// shutdown, serialization failure and deadlock.
+		switch {
+		case strings.HasPrefix(pgErr.Code, "08"), pgErr.Code == "57P01", pgErr.Code == "40001", pgErr.Code == "40P01":
+			return true
+		}
+	}
+	return false
+}
+
+// readOnlyKeywords are the leading keywords of SQL statements that are known
+// not to modify data. WITH is not included as a common table expression may
+// contain a data-modifying statement, nor EXPLAIN as EXPLAIN ANALYZE executes
+// the statement it explains.
+var readOnlyKeywords = []string{"SELECT", "SHOW", "VALUES", "TABLE"}
+
+// isReadOnlySQL returns true if the SQL statement text is known to only read
+// data, judged by its first keyword after any leading comments.
+func isReadOnlySQL(text string) bool {
+	for {
+		text = strings.TrimSpace(text)
+		switch {
+		case strings.HasPrefix(text, "/*"):
+			end := strings.Index(text, "*/")
+			if end < 0 {
+				return false
+			}
+			text = text[end+2:]
+		case strings.HasPrefix(text, "--"):
+			end := strings.IndexByte(text, '\n')
+			if end < 0 {
+				return false
+			}
+			text = text[end+1:]
+		default:
+			fields := strings.Fields(text)
+			if len(fields) == 0 {
+				return false
+			}
+			for _, k := range readOnlyKeywords {
+				if strings.EqualFold(fields[0], k) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyExecutor is a connExecutor that fails the first failures queries it
+// executes with err, or a connection reset if err is nil.
+type flakyExecutor struct {
+	closingExecutor
+	failures int
+	err      error
+	attempts int
+}
+
+func (e *flakyExecutor) executeQuery(ctx context.Context, q query) (queryResult, error) {
+	e.attempts++
+	if e.attempts <= e.failures {
+		if e.err != nil {
+			return queryResult{}, e.err
+		}
+		return queryResult{}, fmt.Errorf("read tcp: %w", syscall.ECONNRESET)
+	}
+	return e.closingExecutor.executeQuery(ctx, q)
+}
+
+// newTestRetryExecutor returns a retryExecutor for exec that records its
+// backoff waits instead of sleeping.
+func newTestRetryExecutor(exec connExecutor, retries int, idempotentOnly bool, text string, waits *[]time.Duration) *retryExecutor {
+	e := newRetryExecutor(exec, retries, 10*time.Millisecond, idempotentOnly, text)
+	e.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return e
+}
+
+func TestRetryExecutor(t *testing.T) {
+	ctx := context.Background()
+
+	var waits []time.Duration
+	flaky := &flakyExecutor{failures: 2}
+	exec := newTestRetryExecutor(flaky, 3, true, "/* bench */ SELECT min(usage) FROM cpu_usage", &waits)
+	qr, err := exec.executeQuery(ctx, good1Query)
+	require.NoError(t, err)
+	require.Equal(t, 3, flaky.attempts)
+	require.Equal(t, 2, qr.retries)
+	require.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}, waits)
+
+	flaky = &flakyExecutor{failures: 2}
+	exec = newTestRetryExecutor(flaky, 3, true, "INSERT INTO cpu_usage VALUES ($1, $2, $3)", &waits)
+	_, err = exec.executeQuery(ctx, good1Query)
+	require.EqualError(t, err, "read tcp: connection reset by peer")
+	require.Equal(t, 1, flaky.attempts)
+
+	flaky = &flakyExecutor{failures: 2}
+	exec = newTestRetryExecutor(flaky, 3, false, "INSERT INTO cpu_usage VALUES ($1, $2, $3)", &waits)
+	_, err = exec.executeQuery(ctx, good1Query)
+	require.NoError(t, err)
+	require.Equal(t, 3, flaky.attempts)
+
+	flaky = &flakyExecutor{failures: 5}
+	exec = newTestRetryExecutor(flaky, 3, true, "SELECT 1", &waits)
+	_, err = exec.executeQuery(ctx, good1Query)
+	require.Error(t, err)
+	require.Equal(t, 4, flaky.attempts)
+
+	// A permanent error fails without retrying.
+	flaky = &flakyExecutor{failures: 1, err: &pgconn.PgError{Code: "42P01", Message: "relation does not exist"}}
+	exec = newTestRetryExecutor(flaky, 3, true, "SELECT 1", &waits)
+	_, err = exec.executeQuery(ctx, good1Query)
+	require.Error(t, err)
+	require.Equal(t, 1, flaky.attempts)
+}
+
+func TestIsTransient(t *testing.T) {
+	require.True(t, isTransient(fmt.Errorf("read: %w", syscall.ECONNRESET)))
+	require.True(t, isTransient(&pgconn.PgError{Code: "08006"}))
+	require.True(t, isTransient(&pgconn.PgError{Code: "40P01"}))
+	require.False(t, isTransient(&pgconn.PgError{Code: "42601"}))
+	require.False(t, isTransient(errors.New("bad query")))
+}
+
+func TestIsReadOnlySQL(t *testing.T) {
+	require.True(t, isReadOnlySQL("SELECT 1"))
+	require.True(t, isReadOnlySQL("  /* a */ -- b\n select 1"))
+	require.False(t, isReadOnlySQL("INSERT INTO t VALUES (1)"))
+	require.False(t, isReadOnlySQL("WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d"))
+	require.False(t, isReadOnlySQL("EXPLAIN ANALYZE DELETE FROM t"))
+	require.False(t, isReadOnlySQL("/* unterminated SELECT 1"))
+	require.False(t, isReadOnlySQL(""))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// slowestResults keeps the n slowest results observed. It is a min-heap by
+// duration, so the fastest of the results kept is replaced by a slower one.
+type slowestResults struct {
+	n       int
+	results []queryResult
+}
+
+func newSlowestResults(n int) *slowestResults {
+	return &slowestResults{n: n}
+}
+
+func (s *slowestResults) Len() int { return len(s.results) }
+func (s *slowestResults) Less(i, j int) bool {
+	return s.results[i].queryDuration < s.results[j].queryDuration
+}
+func (s *slowestResults) Swap(i, j int)      { s.results[i], s.results[j] = s.results[j], s.results[i] }
+func (s *slowestResults) Push(x interface{}) { s.results = append(s.results, x.(queryResult)) }
+func (s *slowestResults) Pop() interface{} {
+	last := s.results[len(s.results)-1]
+	s.results = s.results[:len(s.results)-1]
+	return last
+}
+
+// observe keeps qr if it is one of the n slowest results observed so far.
+func (s *slowestResults) observe(qr queryResult) {
+	if len(s.results) < s.n {
+		heap.Push(s, qr)
+		return
+	}
+	if s.n > 0 && qr.queryDuration > s.results[0].queryDuration {
+		s.results[0] = qr
+		heap.Fix(s, 0)
+	}
+}
+
+// slowest returns the results kept, slowest first. There are fewer than n
+// if fewer results were observed.
+func (s *slowestResults) slowest() []queryResult {
+	results := append([]queryResult(nil), s.results...)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].queryDuration > results[j].queryDuration
+	})
+	return results
+}
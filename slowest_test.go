@@ -0,0 +1,33 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummariseResultsTopSlow(t *testing.T) {
+	var results []queryResult
+	for _, d := range []int{3, 9, 1, 7, 5} {
+		results = append(results, queryResult{query: good1Query, queryDuration: time.Duration(d) * time.Millisecond})
+	}
+	summary, err := summariseWith(&CLI{TopSlow: 3}, results...)
+	require.NoError(t, err)
+	require.Equal(t, []queryResult{results[1], results[3], results[4]}, summary.slowest)
+
+	var buf bytes.Buffer
+	printSlowest(&buf, summary, durationFormat{})
+	require.Equal(t, "Slowest queries: 3\n"+
+		"  host_000008 2017-01-01 08:59:22 - 2017-01-01 09:59:22: 9ms\n"+
+		"  host_000008 2017-01-01 08:59:22 - 2017-01-01 09:59:22: 7ms\n"+
+		"  host_000008 2017-01-01 08:59:22 - 2017-01-01 09:59:22: 5ms\n", buf.String())
+
+	// All the results are reported if there are fewer than N.
+	summary, err = summariseWith(&CLI{TopSlow: 10}, results...)
+	require.NoError(t, err)
+	require.Len(t, summary.slowest, 5)
+	require.Equal(t, 9*time.Millisecond, summary.slowest[0].queryDuration)
+	require.Equal(t, time.Millisecond, summary.slowest[4].queryDuration)
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// benchmarkSQL is the SQL statement executed for each query.
+type benchmarkSQL struct {
+	text string
+
+	// params are the names of the query fields bound to the positional
+	// parameters $1, $2, ... of text: hostname, start or end.
+	params []string
+
+	// minMax is true if text returns a single row of the minimum and
+	// maximum CPU usage. Otherwise all rows returned are read and
+	// discarded.
+	minMax bool
+
+	// buckets is true if text returns a row for each time bucket with the
+	// bucket and the minimum and maximum CPU usage in it.
+	buckets bool
+
+	// aggregate is true if text returns a single row of a single aggregate
+	// over the window, which is NULL if there is no data in it, or zero if
+	// count is also true.
+	aggregate, count bool
+}
+
+// noData returns true if v, the value returned by the aggregate query b,
+// shows there was no data in the window of the query.
+func (b benchmarkSQL) noData(v sql.NullFloat64) bool {
+	return !v.Valid || b.count && v.Float64 == 0
+}
+
+// args returns the values of q to bind to the parameters of the statement.
+func (b benchmarkSQL) args(q query) []interface{} {
+	args := make([]interface{}, len(b.params))
+	for i, p := range b.params {
+		switch p {
+		case "hostname":
+			args[i] = q.hostname
+		case "start":
+			args[i] = q.start
+		case "end":
+			args[i] = q.end
+		}
+	}
+	return args
+}
+
+// defaultSQLTemplate is the SQL template of the default benchmark query.
+const defaultSQLTemplate = "SELECT min({{.ValueColumn}}), max({{.ValueColumn}}) FROM {{.Table}} WHERE {{.HostColumn}} = {{.Hostname}} AND {{.TsColumn}} >= {{.Start}} AND {{.TsColumn}} <= {{.End}}"
+
+// builtinQueries are the SQL templates of the queries that can be selected
+// by name with config.Query.
+var builtinQueries = map[string]string{
+	"minmax":     defaultSQLTemplate,
+	"avg":        "SELECT avg({{.ValueColumn}}) FROM {{.Table}} WHERE {{.HostColumn}} = {{.Hostname}} AND {{.TsColumn}} >= {{.Start}} AND {{.TsColumn}} <= {{.End}}",
+	"count":      "SELECT count(*) FROM {{.Table}} WHERE {{.HostColumn}} = {{.Hostname}} AND {{.TsColumn}} >= {{.Start}} AND {{.TsColumn}} <= {{.End}}",
+	"percentile": "SELECT percentile_cont(0.95) WITHIN GROUP (ORDER BY {{.ValueColumn}}) FROM {{.Table}} WHERE {{.HostColumn}} = {{.Hostname}} AND {{.TsColumn}} >= {{.Start}} AND {{.TsColumn}} <= {{.End}}",
+	"bucket":     "SELECT time_bucket({{.Bucket}}, {{.TsColumn}}) AS bucket, min({{.ValueColumn}}), max({{.ValueColumn}}) FROM {{.Table}} WHERE {{.HostColumn}} = {{.Hostname}} AND {{.TsColumn}} >= {{.Start}} AND {{.TsColumn}} <= {{.End}} GROUP BY 1 ORDER BY 1",
+}
+
+// schemaNames are the names of the table and columns queried by the SQL
+// templates. Empty names are the names of the cpu_usage schema. bucket is
+// the width of the time buckets, one minute if zero.
+type schemaNames struct {
+	table, value, host, ts string
+	bucket                 time.Duration
+}
+
+// newSchemaNames returns the schema names of config.
+func newSchemaNames(config *CLI) schemaNames {
+	return schemaNames{table: config.Table, value: config.ValueColumn, host: config.HostColumn, ts: config.TsColumn, bucket: config.Bucket}
+}
+
+// querySQL returns the SQL of the benchmark query for config. By default
+// it returns the minimum and maximum CPU usage for the hostname, start and
+// end time of a query. If config.Query names one of the builtinQueries, that
+// query is used instead, and if it is other SQL, that SQL is used as is. If
+// config.SQLTemplate is set, the SQL is generated from that template.
+func querySQL(config *CLI) (benchmarkSQL, error) {
+	cast := config.TimestampCast
+	if cast == "none" {
+		cast = ""
+	}
+	tmpl := config.SQLTemplate
+	minMax := tmpl == ""
+	if config.Query != "" {
+		builtin, ok := builtinQueries[config.Query]
+		if !ok {
+			return rawSQL(config.Query, config.QueryComment)
+		}
+		tmpl, minMax = builtin, config.Query == "minmax"
+	}
+	buckets := config.Query == "bucket"
+	if tmpl == "" {
+		tmpl = defaultSQLTemplate
+	}
+	bsql, err := renderSQLTemplate(tmpl, cast, newSchemaNames(config))
+	if err != nil {
+		return benchmarkSQL{}, err
+	}
+	bsql.minMax = minMax
+	bsql.buckets = buckets
+	switch config.Query {
+	case "avg", "percentile":
+		bsql.aggregate = true
+	case "count":
+		bsql.aggregate, bsql.count = true, true
+	}
+	if config.QueryComment != "" {
+		// Validate ensures the comment cannot terminate early.
+		bsql.text = "/* " + config.QueryComment + " */ " + bsql.text
+	}
+	return bsql, nil
+}
+
+// rawSQL returns the benchmark SQL of the SQL text, with the hostname, start
+// and end time of a query bound to the positional parameters $1, $2 and $3.
+// text may use fewer parameters, but no others. If comment is not empty, it
+// is prepended to the SQL as for querySQL.
+func rawSQL(text, comment string) (benchmarkSQL, error) {
+	fields := []string{"hostname", "start", "end"}
+	n := 0
+	for _, m := range sqlParamRE.FindAllStringSubmatch(text, -1) {
+		i, err := strconv.Atoi(m[1])
+		if err != nil || i < 1 || i > len(fields) {
+			return benchmarkSQL{}, fmt.Errorf("invalid query: parameter %s is not one of $1 (hostname), $2 (start) or $3 (end)", m[0])
+		}
+		if i > n {
+			n = i
+		}
+	}
+	if comment != "" {
+		text = "/* " + comment + " */ " + text
+	}
+	return benchmarkSQL{text: text, params: fields[:n]}, nil
+}
+
+// sqlParamRE matches the positional parameters of SQL.
+var sqlParamRE = regexp.MustCompile(`\$(\d+)`)
+
+// renderSQLTemplate executes the Go template tmpl to produce the benchmark
+// SQL. The query values are never interpolated into the SQL: {{.Hostname}},
+// {{.Start}} and {{.End}} render as positional parameters that the values are
+// bound to when the statement is executed. If cast is not empty, the start
+// and end parameters are cast to that type. {{.Table}}, {{.ValueColumn}},
+// {{.HostColumn}} and {{.TsColumn}} render as the identifiers of names, and
+// {{.Bucket}} as the interval of its bucket width.
+func renderSQLTemplate(tmpl, cast string, names schemaNames) (benchmarkSQL, error) {
+	t, err := template.New("sql").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return benchmarkSQL{}, fmt.Errorf("invalid SQL template: %w", err)
+	}
+	params := &sqlTemplateParams{cast: cast, names: names}
+	var sb strings.Builder
+	if err := t.Execute(&sb, params); err != nil {
+		return benchmarkSQL{}, fmt.Errorf("invalid SQL template: %w", err)
+	}
+	return benchmarkSQL{text: sb.String(), params: params.fields}, nil
+}
+
+// sqlTemplateParams is the data for executing a SQL template. Each field
+// renders as a positional parameter, numbered in order of first use.
+type sqlTemplateParams struct {
+	fields []string
+
+	// cast is the type the start and end parameters are cast to, if set.
+	cast string
+
+	names schemaNames
+}
+
+func (p *sqlTemplateParams) Table() string       { return quoteTable(p.names.table, "cpu_usage") }
+func (p *sqlTemplateParams) ValueColumn() string { return quoteIdentifier(p.names.value, "usage") }
+func (p *sqlTemplateParams) HostColumn() string  { return quoteIdentifier(p.names.host, "host") }
+func (p *sqlTemplateParams) TsColumn() string    { return quoteIdentifier(p.names.ts, "ts") }
+
+// Bucket returns the width of the time buckets as an SQL interval.
+func (p *sqlTemplateParams) Bucket() string {
+	if p.names.bucket == 0 {
+		return bucketInterval(time.Minute)
+	}
+	return bucketInterval(p.names.bucket)
+}
+
+func (p *sqlTemplateParams) Hostname() string { return p.param("hostname") }
+func (p *sqlTemplateParams) Start() string    { return p.castParam("start") }
+func (p *sqlTemplateParams) End() string      { return p.castParam("end") }
+
+// castParam returns the positional parameter for the query field with the
+// cast of p, if any.
+func (p *sqlTemplateParams) castParam(field string) string {
+	if p.cast == "" {
+		return p.param(field)
+	}
+	return p.param(field) + "::" + p.cast
+}
+
+// param returns the positional parameter for the query field, allocating
+// the next parameter number if it has not been used before.
+func (p *sqlTemplateParams) param(field string) string {
+	for i, f := range p.fields {
+		if f == field {
+			return fmt.Sprintf("$%d", i+1)
+		}
+	}
+	p.fields = append(p.fields, field)
+	return fmt.Sprintf("$%d", len(p.fields))
+}
+
+// quoteTable returns the table name, which may be qualified by a schema
+// name, e.g. metrics.cpu_usage, as SQL. Each part is quoted as for
+// quoteIdentifier. If name is empty, def is used.
+func quoteTable(name, def string) string {
+	if name == "" {
+		name = def
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part, "")
+	}
+	return strings.Join(parts, ".")
+}
+
+// quoteIdentifier returns the identifier name as SQL. Unless it is a plain
+// lowercase identifier that is not a reserved word, it is quoted, doubling
+// any quotes in it, so that it cannot change the meaning of the SQL it is
+// part of. If name is empty, def is used.
+func quoteIdentifier(name, def string) string {
+	if name == "" {
+		name = def
+	}
+	if plainIdentifierRE.MatchString(name) && !sqlReservedWords[name] {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// plainIdentifierRE matches identifiers that need not be quoted, other than
+// reserved words.
+var plainIdentifierRE = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// sqlReservedWords are the PostgreSQL reserved key words, which must be
+// quoted to be used as identifiers.
+var sqlReservedWords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`all analyse analyze and any array as asc asymmetric authorization
+		binary both case cast check collate collation column concurrently constraint create cross
+		current_catalog current_date current_role current_schema current_time current_timestamp
+		current_user default deferrable desc distinct do else end except false fetch for foreign
+		freeze from full grant group having ilike in initially inner intersect into is isnull join
+		lateral leading left like limit localtime localtimestamp natural not notnull null offset on
+		only or order outer overlaps placing primary references returning right select session_user
+		similar some symmetric system_user table tablesample then to trailing true union unique user
+		using variadic verbose when where window with`) {
+		sqlReservedWords[w] = true
+	}
+}
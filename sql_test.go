@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuerySQLTemplate(t *testing.T) {
+	config := &CLI{
+		SQLTemplate: "SELECT avg(usage) FROM cpu_usage WHERE ts <= {{.End}} AND ts >= {{.Start}} AND host = {{.Hostname}} AND ts < {{.End}} + interval '1 day'",
+	}
+	bsql, err := querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT avg(usage) FROM cpu_usage WHERE ts <= $1 AND ts >= $2 AND host = $3 AND ts < $1 + interval '1 day'", bsql.text)
+	require.False(t, bsql.minMax)
+	require.Equal(t, []interface{}{good1Query.end, good1Query.start, "host_000008"}, bsql.args(good1Query))
+
+	config.SQLTemplate = "SELECT count(*) FROM cpu_usage WHERE host = {{.Hostname}}"
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT count(*) FROM cpu_usage WHERE host = $1", bsql.text)
+	require.Equal(t, []interface{}{"host_000008"}, bsql.args(good1Query))
+
+	config.SQLTemplate = "SELECT {{.Column}}"
+	_, err = querySQL(config)
+	require.Error(t, err)
+
+	config.SQLTemplate = "SELECT {{.Hostname"
+	_, err = querySQL(config)
+	require.Error(t, err)
+}
+
+func TestQuerySQLSchemaNames(t *testing.T) {
+	config := &CLI{Table: "metrics.CPU", ValueColumn: "user", HostColumn: "hostname", TsColumn: `t"s`}
+	bsql, err := querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, `SELECT min("user"), max("user") FROM metrics."CPU" WHERE hostname = $1 AND "t""s" >= $2 AND "t""s" <= $3`, bsql.text)
+
+	config.SQLTemplate = "SELECT count(*) FROM {{.Table}} WHERE {{.HostColumn}} = {{.Hostname}}"
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, `SELECT count(*) FROM metrics."CPU" WHERE hostname = $1`, bsql.text)
+}
+
+func TestQuerySQLTimestampCast(t *testing.T) {
+	config := &CLI{TimestampCast: "timestamp"}
+	bsql, err := querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT min(usage), max(usage) FROM cpu_usage WHERE host = $1 AND ts >= $2::timestamp AND ts <= $3::timestamp", bsql.text)
+	require.True(t, bsql.minMax)
+
+	config.SQLTemplate = "SELECT count(*) FROM cpu_usage WHERE ts BETWEEN {{.Start}} AND {{.End}} AND ts < {{.End}}"
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT count(*) FROM cpu_usage WHERE ts BETWEEN $1::timestamp AND $2::timestamp AND ts < $2::timestamp", bsql.text)
+
+	config = &CLI{TimestampCast: "none"}
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT min(usage), max(usage) FROM cpu_usage WHERE host = $1 AND ts >= $2 AND ts <= $3", bsql.text)
+}
+
+func TestQuerySQLQuery(t *testing.T) {
+	config := &CLI{Query: "count", TimestampCast: "timestamptz"}
+	bsql, err := querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT count(*) FROM cpu_usage WHERE host = $1 AND ts >= $2::timestamptz AND ts <= $3::timestamptz", bsql.text)
+	require.False(t, bsql.minMax)
+	// A count is always a row, so no data is a zero count.
+	require.True(t, bsql.aggregate)
+	require.True(t, bsql.noData(sql.NullFloat64{Float64: 0, Valid: true}))
+	require.False(t, bsql.noData(sql.NullFloat64{Float64: 3, Valid: true}))
+
+	config.Query = "avg"
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.True(t, bsql.aggregate)
+	require.True(t, bsql.noData(sql.NullFloat64{}))
+	require.False(t, bsql.noData(sql.NullFloat64{Float64: 0, Valid: true}))
+
+	config.Query = "minmax"
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.True(t, bsql.minMax)
+
+	config.Query = "SELECT usage FROM cpu_usage WHERE host = $1 AND ts > $2 LIMIT 10"
+	config.QueryComment = "tsbench"
+	bsql, err = querySQL(config)
+	require.NoError(t, err)
+	require.Equal(t, "/* tsbench */ SELECT usage FROM cpu_usage WHERE host = $1 AND ts > $2 LIMIT 10", bsql.text)
+	require.False(t, bsql.minMax)
+	require.Equal(t, []interface{}{"host_000008", good1Query.start}, bsql.args(good1Query))
+
+	config.Query = "SELECT usage FROM cpu_usage WHERE host = $4"
+	_, err = querySQL(config)
+	require.EqualError(t, err, "invalid query: parameter $4 is not one of $1 (hostname), $2 (start) or $3 (end)")
+}
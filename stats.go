@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// statsWindowBuckets is the number of buckets a statsWindow is divided into.
+const statsWindowBuckets = 10
+
+// statsWindow holds the results completed over a sliding window of time, in
+// a ring of buckets each covering an equal part of the window. Results age
+// out of the window a bucket at a time.
+type statsWindow struct {
+	window  time.Duration
+	buckets [statsWindowBuckets]statsBucket
+}
+
+type statsBucket struct {
+	start   time.Time
+	results []queryResult
+}
+
+// newStatsWindow returns an empty statsWindow covering window.
+func newStatsWindow(window time.Duration) *statsWindow {
+	return &statsWindow{window: window}
+}
+
+// width returns the duration covered by each bucket.
+func (w *statsWindow) width() time.Duration {
+	width := w.window / statsWindowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return width
+}
+
+// add adds qr, completed at now, to the window.
+func (w *statsWindow) add(now time.Time, qr queryResult) {
+	start := now.Truncate(w.width())
+	b := &w.buckets[(start.UnixNano()/int64(w.width()))%statsWindowBuckets]
+	if !b.start.Equal(start) {
+		b.start = start
+		b.results = b.results[:0]
+	}
+	b.results = append(b.results, qr)
+}
+
+// results returns the results in the window ending at now.
+func (w *statsWindow) results(now time.Time) []queryResult {
+	var results []queryResult
+	for _, b := range w.buckets {
+		if !b.start.IsZero() && now.Sub(b.start) <= w.window {
+			results = append(results, b.results...)
+		}
+	}
+	return results
+}
+
+// interimStats are the metrics reported in an interim summary.
+type interimStats struct {
+	count          int
+	qps            float64
+	mean, min, max time.Duration
+	p99            time.Duration
+}
+
+// newInterimStats returns the interim metrics of results completed over
+// span, calculating the p99 with method. results is not modified.
+func newInterimStats(results []queryResult, span time.Duration, method string) interimStats {
+	sorted := make([]queryResult, len(results))
+	copy(sorted, results)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].queryDuration < sorted[j].queryDuration
+	})
+	stats := interimStats{
+		count: len(sorted),
+		p99:   calculatePercentile(sorted, 99, method),
+	}
+	if len(sorted) > 0 {
+		var sum time.Duration
+		for _, qr := range sorted {
+			sum += qr.queryDuration
+		}
+		stats.mean = sum / time.Duration(len(sorted))
+		stats.min, stats.max = sorted[0].queryDuration, sorted[len(sorted)-1].queryDuration
+	}
+	stats.setQPS(span)
+	return stats
+}
+
+// runningInterimStats returns the interim metrics of the whole run so far
+// from summary, which is being tallied, over span. Only the p99 is not
+// tallied, so it is set by the caller.
+func runningInterimStats(summary querySummary, span time.Duration) interimStats {
+	stats := interimStats{count: summary.count, min: summary.min, max: summary.max}
+	if summary.count > 0 {
+		stats.mean = summary.sum / time.Duration(summary.count)
+	}
+	stats.setQPS(span)
+	return stats
+}
+
+// setQPS sets the queries per second of stats from its count over span.
+func (s *interimStats) setQPS(span time.Duration) {
+	if span > 0 {
+		s.qps = float64(s.count) / span.Seconds()
+	}
+}
+
+// printInterim writes stats as a single line to w. If window is non-zero,
+// the stats are labelled as being over that window, otherwise over the whole
+// run so far. Durations are formatted by f.
+func printInterim(w io.Writer, stats interimStats, window time.Duration, f durationFormat) {
+	label := "Interim"
+	if window > 0 {
+		label = fmt.Sprintf("Interim (last %v)", window)
+	}
+	fmt.Fprintf(w, "%s: %d queries, %.1f qps, mean %v, min %v, max %v, p99 %v\n", label, stats.count, stats.qps,
+		f.formatRounded(stats.mean), f.formatRounded(stats.min), f.formatRounded(stats.max), f.formatRounded(stats.p99))
+}
+
+// printProgress overwrites the current line of w, a terminal, with the
+// number of queries completed so far in summary.
+func printProgress(w io.Writer, summary querySummary) {
+	fmt.Fprintf(w, "\rQueries executed: %d", summary.count)
+	if failed := summary.errors + summary.timedOut; failed > 0 {
+		fmt.Fprintf(w, " (%d failed)", failed)
+	}
+}
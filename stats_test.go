@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsWindow(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := newStatsWindow(10 * time.Second)
+	var all []queryResult
+	add := func(at time.Duration, d time.Duration) {
+		qr := queryResult{query: good1Query, queryDuration: d}
+		window.add(start.Add(at), qr)
+		all = append(all, qr)
+	}
+
+	// A fast, busy first minute followed by a slow, quiet 10 seconds.
+	for i := 0; i < 600; i++ {
+		add(time.Duration(i)*100*time.Millisecond, time.Millisecond)
+	}
+	for i := 0; i < 5; i++ {
+		add(time.Minute+time.Duration(i)*2*time.Second, 50*time.Millisecond)
+	}
+	now := start.Add(70 * time.Second)
+
+	windowed := newInterimStats(window.results(now), 10*time.Second, "nearest")
+	require.Equal(t, 5, windowed.count)
+	require.Equal(t, 0.5, windowed.qps)
+	require.Equal(t, 50*time.Millisecond, windowed.p99)
+
+	require.Equal(t, 50*time.Millisecond, windowed.mean)
+
+	cumulative := newInterimStats(all, 70*time.Second, "nearest")
+	require.Equal(t, 605, cumulative.count)
+	require.InDelta(t, 8.64, cumulative.qps, 0.01)
+	require.Equal(t, time.Millisecond, cumulative.p99)
+	require.Equal(t, time.Millisecond, cumulative.min)
+	require.Equal(t, 50*time.Millisecond, cumulative.max)
+	require.Equal(t, 850*time.Millisecond/605, cumulative.mean)
+}
+
+func TestRunningInterimStats(t *testing.T) {
+	summary := querySummary{count: 4, sum: 12 * time.Millisecond, min: time.Millisecond, max: 4 * time.Millisecond}
+	stats := runningInterimStats(summary, 2*time.Second)
+	stats.p99 = 4 * time.Millisecond
+	var buf bytes.Buffer
+	printInterim(&buf, stats, 0, durationFormat{})
+	require.Equal(t, "Interim: 4 queries, 2.0 qps, mean 3ms, min 1ms, max 4ms, p99 4ms\n", buf.String())
+
+	buf.Reset()
+	printInterim(&buf, stats, 0, newDurationFormat("ms", 2))
+	require.Equal(t, "Interim: 4 queries, 2.0 qps, mean 3.00ms, min 1.00ms, max 4.00ms, p99 4.00ms\n", buf.String())
+
+	require.Equal(t, interimStats{}, runningInterimStats(querySummary{}, 0))
+}
+
+func TestSummariseResultsProgress(t *testing.T) {
+	var buf syncBuffer
+	config := &CLI{progress: &buf, ProgressInterval: time.Millisecond}
+	input := make(chan queryResult)
+	go func() {
+		for i := 0; i < 3; i++ {
+			input <- queryResult{query: good1Query, queryDuration: time.Millisecond}
+			time.Sleep(5 * time.Millisecond)
+		}
+		input <- queryResult{query: good1Query, err: errors.New("failed")}
+		close(input)
+	}()
+	summary, err := summariseResults(context.Background(), config, input)
+	require.NoError(t, err)
+	require.Equal(t, 3, summary.count)
+	require.Contains(t, buf.String(), "\rQueries executed: 1")
+	require.True(t, strings.HasSuffix(buf.String(), "\rQueries executed: 3 (1 failed)\n"), buf.String())
+}
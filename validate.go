@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The checks run by validate. The exit status of --validate-only is the
+// bitwise OR of the checks that failed, so it is 0 if all checks pass.
+const (
+	// checkConnect fails if the database cannot be reached. The other
+	// database checks are not run if it fails.
+	checkConnect = 1 << iota
+	// checkSchema fails if the benchmark table is missing or lacks a
+	// required column.
+	checkSchema
+	// checkInput fails if the input file cannot be parsed.
+	checkInput
+	// checkHosts fails if any hostname in the input has no rows in the
+	// benchmark table.
+	checkHosts
+	// checkTimezone fails if the session time zone is not UTC, which the
+	// times in the input are interpreted as.
+	checkTimezone
+)
+
+// validateDB is the database access used by validate.
+type validateDB interface {
+	ping(ctx context.Context) error
+	tableColumns(ctx context.Context, table string) ([]string, error)
+	// unknownHosts returns the hosts that have no rows in the benchmark
+	// table.
+	unknownHosts(ctx context.Context, hosts []string) ([]string, error)
+	// timeZone returns the time zone of the database session.
+	timeZone(ctx context.Context) (string, error)
+}
+
+// validate runs the preflight checks against db and the queries in inputs,
+// writing the outcome of each to w. It returns the bitmask of the checks that
+// failed.
+func validate(ctx context.Context, w io.Writer, config *CLI, db validateDB, inputs []io.Reader) int {
+	failed := 0
+	report := func(check int, name string, err error) {
+		if err != nil {
+			fmt.Fprintf(w, "%s: FAILED: %v\n", name, err)
+			failed |= check
+			return
+		}
+		fmt.Fprintf(w, "%s: ok\n", name)
+	}
+
+	hosts, err := inputHosts(ctx, config, inputs)
+	report(checkInput, "Input", err)
+
+	if err := db.ping(ctx); err != nil {
+		report(checkConnect, "Connect", err)
+		return failed
+	}
+	report(checkConnect, "Connect", nil)
+
+	names := newSchemaNames(config)
+	columns, err := db.tableColumns(ctx, quoteTable(names.table, "cpu_usage"))
+	if err == nil {
+		err = checkTable(names, columns)
+	}
+	report(checkSchema, "Schema", err)
+
+	if failed&(checkInput|checkSchema) == 0 {
+		var unknown []string
+		unknown, err = db.unknownHosts(ctx, hosts)
+		if err == nil && len(unknown) > 0 {
+			err = fmt.Errorf("no rows for hosts: %s", strings.Join(unknown, ", "))
+		}
+		report(checkHosts, "Hosts", err)
+	}
+
+	tz, err := db.timeZone(ctx)
+	if err == nil && tz != "UTC" && tz != "Etc/UTC" {
+		err = fmt.Errorf("session time zone is %s, not UTC", tz)
+	}
+	report(checkTimezone, "Time zone", err)
+
+	return failed
+}
+
+// inputHosts returns the distinct hostnames of the queries in inputs.
+func inputHosts(ctx context.Context, config *CLI, inputs []io.Reader) ([]string, error) {
+	queries := make(chan query)
+	errc := make(chan error, 1)
+	go func() {
+		var err error
+		for _, input := range inputs {
+			if _, err = readCSV(ctx, config, input, queries, 0); err != nil {
+				err = nameInputError(input, len(inputs), err)
+				break
+			}
+		}
+		errc <- err
+		close(queries)
+	}()
+
+	seen := map[string]bool{}
+	var hosts []string
+	for q := range queries {
+		if !seen[q.hostname] {
+			seen[q.hostname] = true
+			hosts = append(hosts, q.hostname)
+		}
+	}
+	return hosts, <-errc
+}
+
+func (p sqlProbeDB) unknownHosts(ctx context.Context, hosts []string) ([]string, error) {
+	host := quoteIdentifier(p.names.host, "host")
+	sqlQ := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s = ANY($1)", host, quoteTable(p.names.table, "cpu_usage"), host)
+	rows, err := p.db.QueryContext(ctx, sqlQ, hosts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	known := map[string]bool{}
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, err
+		}
+		known[h] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var unknown []string
+	for _, h := range hosts {
+		if !known[h] {
+			unknown = append(unknown, h)
+		}
+	}
+	return unknown, nil
+}
+
+func (p sqlProbeDB) timeZone(ctx context.Context) (string, error) {
+	var tz string
+	err := p.db.QueryRowContext(ctx, "SHOW TimeZone").Scan(&tz)
+	return tz, err
+}
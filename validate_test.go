@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeValidateDB is a validateDB that does not use a database.
+type fakeValidateDB struct {
+	fakeProbeDB
+	hosts []string
+	tz    string
+}
+
+func (db fakeValidateDB) unknownHosts(ctx context.Context, hosts []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, h := range db.hosts {
+		known[h] = true
+	}
+	var unknown []string
+	for _, h := range hosts {
+		if !known[h] {
+			unknown = append(unknown, h)
+		}
+	}
+	return unknown, nil
+}
+
+func (db fakeValidateDB) timeZone(ctx context.Context) (string, error) {
+	return db.tz, nil
+}
+
+func TestValidate(t *testing.T) {
+	ctx := context.Background()
+	input := "hostname,start_time,end_time\n" +
+		"host_000008,2017-01-01 08:59:22,2017-01-01 09:59:22\n" +
+		"host_000001,2017-01-02 13:02:02,2017-01-02 14:02:02\n"
+	db := fakeValidateDB{
+		fakeProbeDB: fakeProbeDB{columns: schemaNames{}.requiredColumns()},
+		hosts:       []string{"host_000001", "host_000008"},
+		tz:          "UTC",
+	}
+	var buf bytes.Buffer
+	require.Equal(t, 0, validate(ctx, &buf, &CLI{}, db, []io.Reader{strings.NewReader(input)}))
+	require.Equal(t, "Input: ok\nConnect: ok\nSchema: ok\nHosts: ok\nTime zone: ok\n", buf.String())
+
+	buf.Reset()
+	db.hosts = []string{"host_000001"}
+	db.tz = "Australia/Sydney"
+	require.Equal(t, checkHosts|checkTimezone, validate(ctx, &buf, &CLI{}, db, []io.Reader{strings.NewReader(input)}))
+	require.Contains(t, buf.String(), "Hosts: FAILED: no rows for hosts: host_000008\n")
+	require.Contains(t, buf.String(), "Time zone: FAILED: session time zone is Australia/Sydney, not UTC\n")
+
+	buf.Reset()
+	config := &CLI{Table: "metrics.cpu", ValueColumn: "value"}
+	require.Equal(t, checkSchema|checkTimezone, validate(ctx, &buf, config, db, []io.Reader{strings.NewReader(input)}))
+	require.Contains(t, buf.String(), "Schema: FAILED: missing columns: value\n")
+
+	buf.Reset()
+	db.pingErr = errors.New("connection refused")
+	require.Equal(t, checkInput|checkConnect, validate(ctx, &buf, &CLI{}, db, []io.Reader{strings.NewReader("bad")}))
+}